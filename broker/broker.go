@@ -0,0 +1,141 @@
+// Package broker has sinks that publish events to message brokers, registered
+// with webhook.RegisterSink by url scheme:
+//
+//   - nats: publish to a NATS subject, or a JetStream stream with
+//     ?jetstream=true, e.g. nats://localhost:4222/odk.{projectId}.{type}
+//     (where '.' in the values is also replaced, see Topic.ExpandSubject)
+//   - kafka: produce to a Kafka topic, e.g. kafka://localhost:9092/odk.{type}
+//   - mqtt and mqtts: publish to an MQTT topic, e.g. mqtt://localhost:1883/odk/{projectId}/{type}
+//   - redis and rediss: add to a Redis stream, e.g. redis://localhost:6379/odk.{projectId}
+//
+// The path of the url is the topic, see Topic. Each event is published as
+// the same JSON that is sent to webhook APIs, with the same retries.
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+func init() {
+	webhook.RegisterSink("nats", newNatsSink)
+	webhook.RegisterSink("kafka", newKafkaSink)
+	webhook.RegisterSink("mqtt", newMqttSink)
+	webhook.RegisterSink("mqtts", newMqttSink)
+	webhook.RegisterSink("redis", newRedisSink)
+	webhook.RegisterSink("rediss", newRedisSink)
+}
+
+// The time to connect to a broker and publish an event, for each attempt
+const publishTimeout = 10 * time.Second
+
+// The name brokers show for connections, where supported
+const clientName = "central-webhook"
+
+// errHostRequired is returned for urls without a broker host
+var errHostRequired = errors.New("host is required")
+
+// Topic is a topic, subject or stream name, with the event fields in braces
+// replaced by their values, e.g. odk.{projectId}.{type}
+type Topic string
+
+// topicFields are the event fields that may be used in a topic
+var topicFields = map[string]func(event parser.ProcessedEvent) string{
+	"type":    func(event parser.ProcessedEvent) string { return event.Type },
+	"id":      func(event parser.ProcessedEvent) string { return event.ID },
+	"eventId": func(event parser.ProcessedEvent) string { return event.EventId },
+	"projectId": func(event parser.ProcessedEvent) string {
+		if event.ProjectId == 0 {
+			return ""
+		}
+		return strconv.Itoa(event.ProjectId)
+	},
+	"xmlFormId": func(event parser.ProcessedEvent) string { return event.XmlFormId },
+	"dataset":   func(event parser.ProcessedEvent) string { return event.Dataset },
+	"version":   func(event parser.ProcessedEvent) string { return event.Version },
+}
+
+// ParseTopic returns the topic, or an error if it is empty or has a field
+// that is not known
+func ParseTopic(value string) (Topic, error) {
+	if value == "" {
+		return "", errors.New("topic is required")
+	}
+	var err error
+	expand(value, func(field string) string {
+		if topicFields[field] == nil && err == nil {
+			err = fmt.Errorf("unknown field {%s} in topic %q", field, value)
+		}
+		return ""
+	})
+	return Topic(value), err
+}
+
+// Expand returns the topic for an event. Any characters in the values other
+// than letters, digits, '.', '-' and '_' are replaced with '_', as are empty
+// values, so an event cannot add wildcards or levels to a topic.
+func (topic Topic) Expand(event parser.ProcessedEvent) string {
+	return topic.expandValues(event, false)
+}
+
+// ExpandSubject returns the NATS subject for an event, as Expand, but with
+// '.' in the values also replaced, as it separates the subject tokens,
+// e.g. a form id "a.b" is "a_b" rather than two tokens
+func (topic Topic) ExpandSubject(event parser.ProcessedEvent) string {
+	return topic.expandValues(event, true)
+}
+
+// expandValues returns the topic for an event, replacing '.' in the values
+// if it is a separator
+func (topic Topic) expandValues(event parser.ProcessedEvent, dotSeparator bool) string {
+	return expand(string(topic), func(field string) string {
+		value := ""
+		if fieldValue := topicFields[field]; fieldValue != nil {
+			value = fieldValue(event)
+		}
+		if value == "" {
+			return "_"
+		}
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+				return r
+			case r == '.' && !dotSeparator:
+				return r
+			default:
+				return '_'
+			}
+		}, value)
+	})
+}
+
+// expand replaces each {field} in the value with the result of fieldValue
+func expand(value string, fieldValue func(field string) string) string {
+	var result strings.Builder
+	for {
+		start := strings.IndexByte(value, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			break
+		}
+		result.WriteString(value[:start])
+		result.WriteString(fieldValue(value[start+1 : start+end]))
+		value = value[start+end+1:]
+	}
+	result.WriteString(value)
+	return result.String()
+}
+
+// topicFromPath returns the topic in the path of a url, without the leading /
+func topicFromPath(path string) (Topic, error) {
+	return ParseTopic(strings.TrimPrefix(path, "/"))
+}
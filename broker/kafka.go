@@ -0,0 +1,103 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/hotosm/central-webhook/tracing"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// KafkaSink produces each event to a Kafka topic. The record key is the
+// entity or submission id, so events for each are kept in order in one
+// partition. The event is delivered once all in sync replicas have it.
+type KafkaSink struct {
+	Brokers []string // The seed brokers, the others are discovered
+	Topic   Topic
+
+	mu     sync.Mutex
+	client *kgo.Client
+}
+
+// newKafkaSink returns the sink for a kafka url, e.g.
+// kafka://localhost:9092/odk.{type}
+func newKafkaSink(u *url.URL) (webhook.Sink, error) {
+	if u.Host == "" {
+		return nil, errHostRequired
+	}
+	topic, err := topicFromPath(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaSink{Brokers: []string{u.Host}, Topic: topic}, nil
+}
+
+// producer returns the Kafka client, created the first time. The client
+// connects to the brokers when records are produced.
+func (s *KafkaSink) producer() (*kgo.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(s.Brokers...),
+		kgo.ClientID(clientName),
+		kgo.AllowAutoTopicCreation(),
+		kgo.RecordDeliveryTimeout(publishTimeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	s.client = client
+	return s.client, nil
+}
+
+func (s *KafkaSink) Deliver(ctx context.Context, msg webhook.Message) (*webhook.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	client, err := s.producer()
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(webhook.HeaderIdempotencyKey, msg.ID)
+	tracing.Inject(ctx, header)
+
+	record := &kgo.Record{
+		Topic: s.Topic.Expand(msg.Event),
+		Key:   []byte(msg.Event.ID),
+		Value: msg.Payload,
+	}
+	for key, values := range header {
+		for _, value := range values {
+			record.Headers = append(record.Headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+		}
+	}
+
+	if err := client.ProduceSync(ctx, record).FirstErr(); err != nil {
+		return nil, fmt.Errorf("failed to produce to Kafka: %w", err)
+	}
+	return &webhook.Response{}, nil
+}
+
+// Close closes the client, if created. A later event creates it again.
+func (s *KafkaSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	s.client.Close()
+	s.client = nil
+	return nil
+}
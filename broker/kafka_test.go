@@ -0,0 +1,65 @@
+package broker
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// Note this test requires a Kafka broker that creates topics on first use.
+//
+// The easiest way to ensure this is to run the tests with docker compose:
+// docker compose run --rm webhook
+
+func TestKafkaSink(t *testing.T) {
+	kafkaBroker := os.Getenv("CENTRAL_WEBHOOK_KAFKA_BROKER")
+	if len(kafkaBroker) == 0 {
+		// Default
+		kafkaBroker = "kafka:9092"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	// A new topic for each run, so earlier records are not consumed
+	prefix := "odk-test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	endpoint := webhook.Endpoint{URL: "kafka://" + kafkaBroker + "/" + prefix + ".{projectId}"}
+	event := parser.ProcessedEvent{ID: "a", Type: "submission.create", ProjectId: 3, EventId: "evt_1"}
+	result, err := webhook.Send(log, ctx, endpoint, event)
+	is.NoErr(err)
+	is.Equal(result.Attempts, 1)
+
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(kafkaBroker),
+		kgo.ConsumeTopics(prefix+".3"),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	is.NoErr(err)
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	fetches := consumer.PollFetches(ctx)
+	is.NoErr(fetches.Err())
+	records := fetches.Records()
+	is.Equal(len(records), 1)
+	is.Equal(string(records[0].Key), "a")
+
+	headers := map[string]string{}
+	for _, header := range records[0].Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	is.Equal(headers[webhook.HeaderIdempotencyKey], "evt_1")
+	is.Equal(headers["Content-Type"], "application/json")
+}
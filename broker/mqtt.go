@@ -0,0 +1,133 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// MqttSink publishes each event to an MQTT topic. With QoS 1 (the default)
+// or 2, the event is delivered once the broker has acknowledged it.
+type MqttSink struct {
+	Server   string // The broker, e.g. tcp://localhost:1883 or ssl://localhost:8883
+	Username string
+	Password string
+	ClientId string // A random id if ""
+	Topic    Topic
+	QoS      byte
+	Retain   bool
+
+	mu     sync.Mutex
+	client mqtt.Client
+}
+
+// newMqttSink returns the sink for an mqtt or mqtts url, e.g.
+// mqtt://localhost:1883/odk/{projectId}/{type}?qos=1&retain=false&clientId=webhook
+func newMqttSink(u *url.URL) (webhook.Sink, error) {
+	if u.Host == "" {
+		return nil, errHostRequired
+	}
+	topic, err := topicFromPath(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	sink := &MqttSink{
+		Server:   "tcp://" + u.Host,
+		Username: u.User.Username(),
+		ClientId: query.Get("clientId"),
+		Topic:    topic,
+		QoS:      1,
+	}
+	if u.Scheme == "mqtts" {
+		sink.Server = "ssl://" + u.Host
+	}
+	sink.Password, _ = u.User.Password()
+	if value := query.Get("qos"); value != "" {
+		qos, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || qos > 2 {
+			return nil, fmt.Errorf("invalid qos value %q, must be 0, 1 or 2", value)
+		}
+		sink.QoS = byte(qos)
+	}
+	if value := query.Get("retain"); value != "" {
+		if sink.Retain, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid retain value %q", value)
+		}
+	}
+	return sink, nil
+}
+
+// connect returns the client, connecting the first time. The client
+// reconnects by itself once connected.
+func (s *MqttSink) connect(ctx context.Context) (mqtt.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(s.Server).
+		SetClientID(s.ClientId).
+		SetUsername(s.Username).
+		SetPassword(s.Password).
+		SetAutoReconnect(true).
+		SetConnectTimeout(publishTimeout)
+	if s.ClientId == "" {
+		options.SetClientID(clientName + "-" + rand.Text())
+	}
+	client := mqtt.NewClient(options)
+	if err := wait(ctx, client.Connect()); err != nil {
+		client.Disconnect(0)
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	s.client = client
+	return s.client, nil
+}
+
+func (s *MqttSink) Deliver(ctx context.Context, msg webhook.Message) (*webhook.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	client, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := wait(ctx, client.Publish(s.Topic.Expand(msg.Event), s.QoS, s.Retain, msg.Payload)); err != nil {
+		return nil, fmt.Errorf("failed to publish to MQTT broker: %w", err)
+	}
+	return &webhook.Response{}, nil
+}
+
+// The time to wait for publishes in progress when disconnecting, in ms
+const disconnectQuiesce = 250
+
+// Close disconnects the client, if connected. A later event connects again.
+func (s *MqttSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	s.client.Disconnect(disconnectQuiesce)
+	s.client = nil
+	return nil
+}
+
+// wait waits for an MQTT operation to finish, or the context to be done
+func wait(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,56 @@
+package broker
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// Note this test requires an MQTT broker that allows anonymous clients.
+//
+// The easiest way to ensure this is to run the tests with docker compose:
+// docker compose run --rm webhook
+
+func TestMqttSink(t *testing.T) {
+	mqttBroker := os.Getenv("CENTRAL_WEBHOOK_MQTT_BROKER")
+	if len(mqttBroker) == 0 {
+		// Default
+		mqttBroker = "mqtt:1883"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	received := make(chan mqtt.Message, 1)
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + mqttBroker))
+	is.NoErr(wait(ctx, client.Connect()))
+	defer client.Disconnect(0)
+	is.NoErr(wait(ctx, client.Subscribe("odk-test/#", 1, func(_ mqtt.Client, msg mqtt.Message) {
+		received <- msg
+	})))
+
+	// Events are published to the topic for their project and form
+	endpoint := webhook.Endpoint{URL: "mqtt://" + mqttBroker + "/odk-test/{projectId}/{xmlFormId}"}
+	event := parser.ProcessedEvent{ID: "a", Type: "submission.create", ProjectId: 3, XmlFormId: "buildings"}
+	result, err := webhook.Send(log, ctx, endpoint, event)
+	is.NoErr(err)
+	is.Equal(result.Attempts, 1)
+
+	select {
+	case msg := <-received:
+		is.Equal(msg.Topic(), "odk-test/3/buildings")
+		is.True(len(msg.Payload()) > 0)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
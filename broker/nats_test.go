@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// Note this test requires a NATS server with JetStream enabled.
+//
+// The easiest way to ensure this is to run the tests with docker compose:
+// docker compose run --rm webhook
+
+func natsUrl() string {
+	natsUrl := os.Getenv("CENTRAL_WEBHOOK_NATS_URL")
+	if len(natsUrl) == 0 {
+		// Default
+		natsUrl = "nats://nats:4222"
+	}
+	return natsUrl
+}
+
+func TestNatsSink(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	conn, err := nats.Connect(natsUrl())
+	is.NoErr(err)
+	defer conn.Close()
+	sub, err := conn.SubscribeSync("odk.>")
+	is.NoErr(err)
+	is.NoErr(conn.Flush())
+
+	// Events are published to the subject for their project and type
+	endpoint := webhook.Endpoint{URL: natsUrl() + "/odk.{projectId}.{type}"}
+	event := parser.ProcessedEvent{ID: "a", Type: "submission.create", ProjectId: 3, EventId: "evt_1"}
+	result, err := webhook.Send(log, ctx, endpoint, event)
+	is.NoErr(err)
+	is.Equal(result.Attempts, 1)
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	is.NoErr(err)
+	is.Equal(msg.Subject, "odk.3.submission_create")
+	is.Equal(msg.Header.Get(webhook.HeaderIdempotencyKey), "evt_1")
+	is.Equal(msg.Header.Get("Content-Type"), "application/json")
+	is.True(len(msg.Data) > 0)
+}
+
+func TestNatsSinkJetStream(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	conn, err := nats.Connect(natsUrl())
+	is.NoErr(err)
+	defer conn.Close()
+	js, err := jetstream.New(conn)
+	is.NoErr(err)
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     "CENTRAL_WEBHOOK_TEST",
+		Subjects: []string{"odk-test.>"},
+	})
+	is.NoErr(err)
+	is.NoErr(stream.Purge(ctx))
+
+	// The event is stored once, though it is sent twice
+	endpoint := webhook.Endpoint{URL: natsUrl() + "/odk-test.{type}?jetstream=true"}
+	event := parser.ProcessedEvent{ID: "a", Type: "entity.update.version", EventId: "evt_2"}
+	_, err = webhook.Send(log, ctx, endpoint, event)
+	is.NoErr(err)
+	_, err = webhook.Send(log, ctx, endpoint, event)
+	is.NoErr(err)
+
+	info, err := stream.Info(ctx)
+	is.NoErr(err)
+	is.Equal(info.State.Msgs, uint64(1))
+
+	// A subject without a stream fails
+	policy := webhook.RetryPolicy{MaxAttempts: 1}
+	endpoint = webhook.Endpoint{URL: natsUrl() + "/odk-missing.{type}?jetstream=true", RetryPolicy: &policy}
+	_, err = webhook.Send(log, ctx, endpoint, event)
+	is.True(err != nil)
+}
@@ -0,0 +1,114 @@
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// RedisSink adds each event to a Redis stream, with the fields messageId,
+// type and event (the JSON). The event is delivered once it is added.
+type RedisSink struct {
+	Addr     string // e.g. localhost:6379
+	Username string
+	Password string
+	DB       int
+	TLS      bool
+	Stream   Topic
+	MaxLen   int64 // Trim the stream to about this many events, if > 0
+
+	mu     sync.Mutex
+	client *redis.Client
+}
+
+// newRedisSink returns the sink for a redis or rediss url, e.g.
+// redis://localhost:6379/odk.{projectId}?db=0&maxLen=100000
+func newRedisSink(u *url.URL) (webhook.Sink, error) {
+	if u.Host == "" {
+		return nil, errHostRequired
+	}
+	stream, err := topicFromPath(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	sink := &RedisSink{
+		Addr:     u.Host,
+		Username: u.User.Username(),
+		TLS:      u.Scheme == "rediss",
+		Stream:   stream,
+	}
+	sink.Password, _ = u.User.Password()
+	if value := query.Get("db"); value != "" {
+		if sink.DB, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid db value %q", value)
+		}
+	}
+	if value := query.Get("maxLen"); value != "" {
+		if sink.MaxLen, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid maxLen value %q", value)
+		}
+	}
+	return sink, nil
+}
+
+// redisClient returns the client, created the first time. The client
+// connects when events are added.
+func (s *RedisSink) redisClient() *redis.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client
+	}
+
+	options := &redis.Options{
+		Addr:       s.Addr,
+		Username:   s.Username,
+		Password:   s.Password,
+		DB:         s.DB,
+		ClientName: clientName,
+		// Events are retried by the retry policy of the endpoint instead
+		MaxRetries:    -1,
+		DialerRetries: 1,
+	}
+	if s.TLS {
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	s.client = redis.NewClient(options)
+	return s.client
+}
+
+func (s *RedisSink) Deliver(ctx context.Context, msg webhook.Message) (*webhook.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	err := s.redisClient().XAdd(ctx, &redis.XAddArgs{
+		Stream: s.Stream.Expand(msg.Event),
+		MaxLen: s.MaxLen,
+		Approx: true,
+		Values: []any{"messageId", msg.ID, "type", msg.Event.Type, "event", msg.Payload},
+	}).Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to add to Redis stream: %w", err)
+	}
+	return &webhook.Response{}, nil
+}
+
+// Close closes the client, if created. A later event creates it again.
+func (s *RedisSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
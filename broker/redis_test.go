@@ -0,0 +1,54 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+func TestRedisSink(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+
+	// Events are added to the stream for their project, with the event JSON
+	endpoint := webhook.Endpoint{URL: "redis://" + server.Addr() + "/odk.{projectId}"}
+	event := parser.ProcessedEvent{ID: "a", Type: "entity.update.version", ProjectId: 3, EventId: "evt_1"}
+	result, err := webhook.Send(log, ctx, endpoint, event)
+	is.NoErr(err)
+	is.Equal(result.Attempts, 1)
+	_, err = webhook.Send(log, ctx, endpoint, parser.ProcessedEvent{ID: "b", Type: "submission.create", ProjectId: 4})
+	is.NoErr(err)
+
+	entries, err := server.Stream("odk.3")
+	is.NoErr(err)
+	is.Equal(len(entries), 1)
+	values := entries[0].Values
+	is.Equal(values[:4], []string{"messageId", "evt_1", "type", "entity.update.version"})
+	is.Equal(values[4], "event")
+	var published parser.ProcessedEvent
+	is.NoErr(json.Unmarshal([]byte(values[5]), &published))
+	is.Equal(published.ID, "a")
+	is.Equal(published.EventId, "evt_1")
+	entries, err = server.Stream("odk.4")
+	is.NoErr(err)
+	is.Equal(len(entries), 1)
+
+	// Events are retried while the server is down
+	server.Close()
+	policy := webhook.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	endpoint.RetryPolicy = &policy
+	result, err = webhook.Send(log, ctx, endpoint, event)
+	is.True(err != nil)
+	is.Equal(result.Attempts, 2)
+}
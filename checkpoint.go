@@ -0,0 +1,140 @@
+// Track the audit events processed, to skip duplicates and resume after a restart
+
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hotosm/central-webhook/db"
+)
+
+// The cursor name, for the events on the odk-events channel
+const cursorName = "odk-events"
+
+// The number of processed audit ids remembered, to skip duplicates
+const checkpointSeenSize = 10000
+
+// checkpoint tracks the audit events started and processed (delivered or
+// dead lettered), so an event received more than once, from a notification,
+// the outbox and the audits table, is only delivered once. The highest
+// processed audit id below any still in progress or failed is saved as the
+// cursor, to catch up from after a restart, so no event before the cursor
+// is skipped, though events may be delivered in any order.
+//
+// Events without an audit id are not tracked.
+type checkpoint struct {
+	save func(db.Cursor) error // Saves the cursor when it moves forward
+
+	mu     sync.Mutex
+	cursor db.Cursor
+	seen   map[int64]struct{} // Audit ids started or processed
+	order  []int64            // Processed audit ids, oldest first, to forget
+
+	// Audit ids after the cursor, which it cannot move past until processed
+	pending map[int64]struct{} // Started
+	failed  map[int64]struct{} // Failed, until started again and processed
+	// Audit ids after the cursor that were processed, with their loggedAt
+	processed map[int64]time.Time
+}
+
+func newCheckpoint(cursor db.Cursor, save func(db.Cursor) error) *checkpoint {
+	return &checkpoint{
+		save:      save,
+		cursor:    cursor,
+		seen:      map[int64]struct{}{},
+		pending:   map[int64]struct{}{},
+		failed:    map[int64]struct{}{},
+		processed: map[int64]time.Time{},
+	}
+}
+
+// Cursor returns the highest processed audit event, with every event
+// started before it processed
+func (c *checkpoint) Cursor() db.Cursor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cursor
+}
+
+// Start returns false if the audit event was already started, else marks
+// it started, to be passed to Done once processed. A nil checkpoint starts
+// every event.
+func (c *checkpoint) Start(auditId int64) bool {
+	if c == nil || auditId == 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.seen[auditId]; ok {
+		return false
+	}
+	c.seen[auditId] = struct{}{}
+	if auditId > c.cursor.AuditId {
+		c.pending[auditId] = struct{}{}
+	}
+	return true
+}
+
+// Done marks a started audit event processed, saving the cursor if it moved
+// forward. If the event failed, it is forgotten, so it can be started again,
+// e.g. on the next outbox drain, and the cursor does not move past it until
+// it is processed.
+func (c *checkpoint) Done(auditId int64, loggedAt time.Time, err error) error {
+	if c == nil || auditId == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, auditId)
+	if err != nil {
+		delete(c.seen, auditId)
+		if auditId > c.cursor.AuditId {
+			c.failed[auditId] = struct{}{}
+		}
+		return nil
+	}
+	delete(c.failed, auditId)
+
+	c.order = append(c.order, auditId)
+	if len(c.order) > checkpointSeenSize {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	if auditId <= c.cursor.AuditId {
+		return nil
+	}
+	c.processed[auditId] = loggedAt
+
+	// Move to the highest processed id before the first that is not
+	lowest := int64(math.MaxInt64)
+	for id := range c.pending {
+		lowest = min(lowest, id)
+	}
+	for id := range c.failed {
+		lowest = min(lowest, id)
+	}
+	cursor := c.cursor
+	for id, loggedAt := range c.processed {
+		if id < lowest && id > cursor.AuditId {
+			cursor = db.Cursor{AuditId: id, LoggedAt: loggedAt}
+		}
+	}
+	if cursor == c.cursor {
+		return nil
+	}
+	for id := range c.processed {
+		if id <= cursor.AuditId {
+			delete(c.processed, id)
+		}
+	}
+	c.cursor = cursor
+	if c.save == nil {
+		return nil
+	}
+	return c.save(c.cursor)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/db"
+)
+
+func TestCheckpoint(t *testing.T) {
+	is := is.New(t)
+
+	var saved []db.Cursor
+	checkpoint := newCheckpoint(db.Cursor{AuditId: 10}, func(cursor db.Cursor) error {
+		saved = append(saved, cursor)
+		return nil
+	})
+	loggedAt := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+
+	// An event is only started once, e.g. from the outbox then a notification
+	is.True(checkpoint.Start(12))
+	is.True(!checkpoint.Start(12))
+	is.NoErr(checkpoint.Done(12, loggedAt, nil))
+	is.True(!checkpoint.Start(12))
+	is.Equal(saved, []db.Cursor{{AuditId: 12, LoggedAt: loggedAt}})
+
+	// Events before the cursor are delivered, e.g. committed late, without
+	// moving the cursor back
+	is.True(checkpoint.Start(11))
+	is.NoErr(checkpoint.Done(11, loggedAt, nil))
+	is.Equal(len(saved), 1)
+	is.Equal(checkpoint.Cursor().AuditId, int64(12))
+
+	// Failed events can be started again, and do not move the cursor
+	is.True(checkpoint.Start(13))
+	is.NoErr(checkpoint.Done(13, loggedAt, errors.New("failed")))
+	is.True(checkpoint.Start(13))
+	is.Equal(checkpoint.Cursor().AuditId, int64(12))
+
+	// Events without an audit id are not tracked
+	is.True(checkpoint.Start(0))
+	is.True(checkpoint.Start(0))
+}
+
+func TestCheckpointOutOfOrder(t *testing.T) {
+	is := is.New(t)
+
+	var saved []db.Cursor
+	checkpoint := newCheckpoint(db.Cursor{}, func(cursor db.Cursor) error {
+		saved = append(saved, cursor)
+		return nil
+	})
+	loggedAt := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+
+	// The cursor does not move past an event in progress, or that failed
+	is.True(checkpoint.Start(1))
+	is.True(checkpoint.Start(2))
+	is.True(checkpoint.Start(4))
+	is.NoErr(checkpoint.Done(2, loggedAt, nil))
+	is.NoErr(checkpoint.Done(1, loggedAt, errors.New("failed")))
+	is.Equal(checkpoint.Cursor().AuditId, int64(0))
+	is.Equal(len(saved), 0)
+
+	// Once delivered, it moves to the highest before any still in progress
+	is.True(checkpoint.Start(1))
+	is.NoErr(checkpoint.Done(1, loggedAt, nil))
+	is.Equal(saved, []db.Cursor{{AuditId: 2, LoggedAt: loggedAt}})
+	is.NoErr(checkpoint.Done(4, loggedAt, nil))
+	is.Equal(checkpoint.Cursor().AuditId, int64(4))
+	is.Equal(len(checkpoint.processed), 0)
+}
+
+func TestNilCheckpoint(t *testing.T) {
+	is := is.New(t)
+
+	// Starts every event, as for the replay subcommand
+	var checkpoint *checkpoint
+	is.True(checkpoint.Start(12))
+	is.NoErr(checkpoint.Done(12, time.Time{}, nil))
+	is.True(checkpoint.Start(12))
+}
+
+func TestCheckpointForgetsOldest(t *testing.T) {
+	is := is.New(t)
+
+	checkpoint := newCheckpoint(db.Cursor{}, nil)
+	for id := int64(1); id <= checkpointSeenSize+1; id++ {
+		is.True(checkpoint.Start(id))
+		is.NoErr(checkpoint.Done(id, time.Time{}, nil))
+	}
+	is.Equal(len(checkpoint.seen), checkpointSeenSize)
+	is.True(checkpoint.Start(1)) // forgotten
+	is.True(!checkpoint.Start(2))
+	is.Equal(checkpoint.Cursor().AuditId, int64(checkpointSeenSize+1))
+}
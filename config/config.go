@@ -0,0 +1,360 @@
+// Load the service configuration from a YAML or JSON file
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hotosm/central-webhook/router"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// Example config file (JSON is also valid YAML, so may be used instead):
+//
+//	database:
+//	  uri: postgresql://odk:${DB_PASSWORD}@db:5432/odk?sslmode=disable
+//	log:
+//	  level: info
+//	retry:
+//	  maxAttempts: 5
+//	endpoints:
+//	  - name: project-1
+//	    url: https://project1.domain.com/webhook
+//	    headers:
+//	      X-Source: central
+//	    auth:
+//	      apiKey: ${PROJECT_1_API_KEY}
+//	      signingSecrets: [${PROJECT_1_SIGNING_SECRET}]
+//	  - name: debug
+//	    url: file:///var/log/central-webhook/events.jsonl
+//	routes:
+//	  - events: ["submission.*"]
+//	    projects: [1]
+//	    endpoints: [project-1, debug]
+
+// Config is the service configuration
+type Config struct {
+	Database  Database   `yaml:"database"`
+	Log       Log        `yaml:"log"`
+	Retry     *Retry     `yaml:"retry"` // The default retry policy for all endpoints
+	HTTP      *HTTP      `yaml:"http"`  // The default HTTP client options for configured endpoints
+	Delivery  Delivery   `yaml:"delivery"`
+	Endpoints []Endpoint `yaml:"endpoints"`
+	Routes    []Route    `yaml:"routes"`
+}
+
+type Database struct {
+	URI string `yaml:"uri"` // postgresql://{user}:{password}@{hostname}/{db}?sslmode=disable
+}
+
+type Log struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // json (default) or text
+}
+
+// Delivery configures how events are received and delivered
+type Delivery struct {
+	Concurrency int    `yaml:"concurrency"` // The number of webhook requests sent at the same time
+	BufferSize  int    `yaml:"bufferSize"`  // The number of database notifications buffered
+	Overflow    string `yaml:"overflow"`    // block, drop-newest, drop-oldest or spill
+	SpillDir    string `yaml:"spillDir"`    // The directory for spilled notifications
+}
+
+// Retry overrides the fields of a retry policy that are set
+type Retry struct {
+	MaxAttempts          *int           `yaml:"maxAttempts"`
+	BaseDelay            *time.Duration `yaml:"baseDelay"` // e.g. 1s
+	MaxDelay             *time.Duration `yaml:"maxDelay"`
+	Jitter               *float64       `yaml:"jitter"`
+	RetryableStatusCodes []int          `yaml:"retryableStatusCodes"`
+	RespectRetryAfter    *bool          `yaml:"respectRetryAfter"`
+}
+
+// HTTP overrides the HTTP client options that are set, see webhook.ClientOptions
+type HTTP struct {
+	Timeout               *time.Duration `yaml:"timeout"` // The total time for each request
+	ConnectTimeout        *time.Duration `yaml:"connectTimeout"`
+	TLSHandshakeTimeout   *time.Duration `yaml:"tlsHandshakeTimeout"`
+	ResponseHeaderTimeout *time.Duration `yaml:"responseHeaderTimeout"`
+	IdleConnTimeout       *time.Duration `yaml:"idleConnTimeout"`
+	MaxIdleConns          *int           `yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost   *int           `yaml:"maxIdleConnsPerHost"`
+	MaxConnsPerHost       *int           `yaml:"maxConnsPerHost"`
+	HTTP2                 *bool          `yaml:"http2"` // true by default
+	// The proxy url, or "" for no proxy. By default the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables are used.
+	Proxy *string `yaml:"proxy"`
+}
+
+// Endpoint is a webhook API, or another sink (see webhook.Sink), referenced
+// by name in routes. Only http and https endpoints have headers, auth, tls
+// and http options.
+type Endpoint struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Args    []string          `yaml:"args"` // The command arguments, for exec urls
+	Headers map[string]string `yaml:"headers"`
+	Auth    *Auth             `yaml:"auth"`
+	TLS     *TLS              `yaml:"tls"`
+	HTTP    *HTTP             `yaml:"http"`  // Overrides the default HTTP client options
+	Retry   *Retry            `yaml:"retry"` // Overrides the default retry policy
+}
+
+// TLS is the TLS config for an endpoint, for internal CAs and mutual TLS
+type TLS struct {
+	CAFile     string `yaml:"caFile"`     // PEM CA certificates, instead of the system CAs
+	CertFile   string `yaml:"certFile"`   // PEM client certificate, with keyFile
+	KeyFile    string `yaml:"keyFile"`    // PEM client private key
+	ServerName string `yaml:"serverName"` // Overrides the server name to verify
+	MinVersion string `yaml:"minVersion"` // 1.0, 1.1, 1.2 (default) or 1.3
+}
+
+// Auth is the authentication for an endpoint, with at most one of apiKey,
+// bearerToken, basic or oauth2 set, and optionally secrets to sign requests with
+type Auth struct {
+	ApiKey         string     `yaml:"apiKey"`
+	BearerToken    string     `yaml:"bearerToken"`
+	Basic          *BasicAuth `yaml:"basic"`
+	OAuth2         *OAuth2    `yaml:"oauth2"`
+	SigningSecrets []string   `yaml:"signingSecrets"`
+}
+
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// OAuth2 is the OAuth2 client credentials grant, see webhook.OAuth2
+type OAuth2 struct {
+	TokenURL     string            `yaml:"tokenUrl"`
+	ClientId     string            `yaml:"clientId"`
+	ClientSecret string            `yaml:"clientSecret"`
+	Scopes       []string          `yaml:"scopes"`
+	Params       map[string]string `yaml:"params"` // e.g. audience
+}
+
+// Route sends matching events to endpoints, see router.Route
+type Route struct {
+	Name      string   `yaml:"name"`
+	Events    []string `yaml:"events"`
+	Projects  []int    `yaml:"projects"`
+	Forms     []string `yaml:"forms"`
+	Datasets  []string `yaml:"datasets"`
+	Endpoints []string `yaml:"endpoints"` // Endpoint names
+}
+
+// Load reads and validates the config file at path
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse parses and validates YAML or JSON config.
+//
+// Environment variables are interpolated in values, as ${VAR}, or
+// ${VAR:-default} if the variable may be unset. All problems found are
+// returned together, each prefixed with the line number.
+func Parse(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if len(root.Content) == 0 {
+		// An empty file, which is invalid without endpoints or routes
+		return nil, errors.New("config is empty")
+	}
+	doc := root.Content[0]
+
+	var errs []error
+	errs = append(errs, interpolate(doc)...)
+	errs = append(errs, checkFields(doc, "", configType)...)
+
+	if err := doc.Decode(&cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, msg := range typeErr.Errors {
+				errs = append(errs, errors.New(msg))
+			}
+		} else {
+			errs = append(errs, err)
+		}
+	} else {
+		// Only validate values if all were decoded, to not report them twice
+		errs = append(errs, cfg.validate(nodeLines(doc))...)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &cfg, nil
+}
+
+// Apply returns the policy with the fields set in the config overridden
+func (retry *Retry) Apply(policy webhook.RetryPolicy) webhook.RetryPolicy {
+	if retry == nil {
+		return policy
+	}
+	if retry.MaxAttempts != nil {
+		policy.MaxAttempts = *retry.MaxAttempts
+	}
+	if retry.BaseDelay != nil {
+		policy.BaseDelay = *retry.BaseDelay
+	}
+	if retry.MaxDelay != nil {
+		policy.MaxDelay = *retry.MaxDelay
+	}
+	if retry.Jitter != nil {
+		policy.Jitter = *retry.Jitter
+	}
+	if retry.RetryableStatusCodes != nil {
+		policy.RetryableStatusCodes = retry.RetryableStatusCodes
+	}
+	if retry.RespectRetryAfter != nil {
+		policy.RespectRetryAfter = *retry.RespectRetryAfter
+	}
+	return policy
+}
+
+// Apply returns the options with the fields set in the config overridden
+func (h *HTTP) Apply(opts webhook.ClientOptions) webhook.ClientOptions {
+	if h == nil {
+		return opts
+	}
+	if h.Timeout != nil {
+		opts.Timeout = *h.Timeout
+	}
+	if h.ConnectTimeout != nil {
+		opts.ConnectTimeout = *h.ConnectTimeout
+	}
+	if h.TLSHandshakeTimeout != nil {
+		opts.TLSHandshakeTimeout = *h.TLSHandshakeTimeout
+	}
+	if h.ResponseHeaderTimeout != nil {
+		opts.ResponseHeaderTimeout = *h.ResponseHeaderTimeout
+	}
+	if h.IdleConnTimeout != nil {
+		opts.IdleConnTimeout = *h.IdleConnTimeout
+	}
+	if h.MaxIdleConns != nil {
+		opts.MaxIdleConns = *h.MaxIdleConns
+	}
+	if h.MaxIdleConnsPerHost != nil {
+		opts.MaxIdleConnsPerHost = *h.MaxIdleConnsPerHost
+	}
+	if h.MaxConnsPerHost != nil {
+		opts.MaxConnsPerHost = *h.MaxConnsPerHost
+	}
+	if h.HTTP2 != nil {
+		opts.DisableHTTP2 = !*h.HTTP2
+	}
+	if h.Proxy != nil {
+		// Validated when the config is loaded, and an empty url is no proxy
+		proxyUrl, _ := url.Parse(*h.Proxy)
+		if *h.Proxy == "" {
+			proxyUrl = nil
+		}
+		opts.Proxy = http.ProxyURL(proxyUrl)
+	}
+	return opts
+}
+
+// WebhookEndpoints returns the configured endpoints, each with its own HTTP
+// client. Endpoints without their own retry config use defaultPolicy, with
+// any overrides applied to it. Certificate files are read again, so an error
+// is returned if they have changed since the config was loaded and are now
+// invalid.
+func (cfg *Config) WebhookEndpoints(defaultPolicy webhook.RetryPolicy) ([]webhook.Endpoint, error) {
+	defaultOptions := cfg.HTTP.Apply(webhook.ClientOptions{})
+
+	endpoints := make([]webhook.Endpoint, 0, len(cfg.Endpoints))
+	for _, endpoint := range cfg.Endpoints {
+		policy := endpoint.Retry.Apply(defaultPolicy)
+		webhookEndpoint := webhook.Endpoint{
+			Name:        endpoint.Name,
+			URL:         endpoint.URL,
+			Headers:     endpoint.Headers,
+			RetryPolicy: &policy,
+		}
+
+		// Other sinks have no HTTP client or auth
+		if sink := webhook.SinkForUrl(endpoint.URL); sink != nil {
+			if execSink, ok := sink.(webhook.ExecSink); ok {
+				execSink.Args = endpoint.Args
+				sink = execSink
+			}
+			webhookEndpoint.Sink = sink
+			endpoints = append(endpoints, webhookEndpoint)
+			continue
+		}
+
+		options := endpoint.HTTP.Apply(defaultOptions)
+		if endpoint.TLS != nil {
+			tlsConfig, err := endpoint.TLS.Config()
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", endpoint.Name, err)
+			}
+			options.TLSConfig = tlsConfig
+		}
+		webhookEndpoint.Client = webhook.NewClient(options)
+
+		if auth := endpoint.Auth; auth != nil {
+			webhookEndpoint.Auth = &webhook.Auth{
+				ApiKey:         auth.ApiKey,
+				BearerToken:    auth.BearerToken,
+				SigningSecrets: auth.SigningSecrets,
+			}
+			if auth.Basic != nil {
+				webhookEndpoint.Auth.Basic = &webhook.BasicAuth{
+					Username: auth.Basic.Username,
+					Password: auth.Basic.Password,
+				}
+			}
+			if auth.OAuth2 != nil {
+				webhookEndpoint.Auth.OAuth2 = &webhook.OAuth2{
+					TokenURL:     auth.OAuth2.TokenURL,
+					ClientId:     auth.OAuth2.ClientId,
+					ClientSecret: auth.OAuth2.ClientSecret,
+					Scopes:       auth.OAuth2.Scopes,
+					Params:       auth.OAuth2.Params,
+					Client:       webhookEndpoint.Client, // the token endpoint may use the same CA or proxy
+				}
+			}
+		}
+
+		endpoints = append(endpoints, webhookEndpoint)
+	}
+	return endpoints, nil
+}
+
+// RouterRoutes returns the configured routes, referencing endpoints by name
+func (cfg *Config) RouterRoutes() []router.Route {
+	routes := make([]router.Route, 0, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		routes = append(routes, router.Route{
+			Name:       route.Name,
+			Events:     route.Events,
+			ProjectIds: route.Projects,
+			XmlFormIds: route.Forms,
+			Datasets:   route.Datasets,
+			Endpoints:  route.Endpoints,
+		})
+	}
+	return routes
+}
@@ -0,0 +1,358 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	// Registers the message broker sinks, as in main
+	_ "github.com/hotosm/central-webhook/broker"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+const validConfig = `
+database:
+  uri: postgresql://odk:${TEST_CONFIG_DB_PASSWORD}@db:5432/odk?sslmode=disable
+log:
+  level: debug
+retry:
+  maxAttempts: ${TEST_CONFIG_MAX_ATTEMPTS:-3}
+  baseDelay: 2s
+http:
+  timeout: 30s
+  maxIdleConnsPerHost: 32
+endpoints:
+  - name: project-1
+    url: https://project1.example.com/webhook
+    headers:
+      X-Source: central
+    auth:
+      apiKey: ${TEST_CONFIG_API_KEY}
+      signingSecrets:
+        - whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw
+        - old-secret
+  - name: project-2
+    url: https://project2.example.com/webhook
+    auth:
+      basic:
+        username: odk
+        password: "${TEST_CONFIG_MISSING:-}"
+    retry:
+      maxAttempts: 10
+    http:
+      timeout: 5s
+      http2: false
+      proxy: http://proxy.example.com:3128
+  - name: project-3
+    url: https://project3.example.com/webhook
+    auth:
+      oauth2:
+        tokenUrl: https://auth.example.com/oauth/token
+        clientId: central-webhook
+        clientSecret: ${TEST_CONFIG_MISSING:-secret}
+        scopes: [webhooks]
+        params:
+          audience: https://project3.example.com
+routes:
+  - name: project 1 submissions
+    events: ["submission.*"]
+    projects: [1]
+    endpoints: [project-1]
+  - events: ["entity.*", "dataset.*"]
+    datasets: [trees]
+    endpoints: [project-1, project-2]
+`
+
+func TestParse(t *testing.T) {
+	is := is.New(t)
+	t.Setenv("TEST_CONFIG_DB_PASSWORD", "secret")
+	t.Setenv("TEST_CONFIG_API_KEY", "my: key # not a comment")
+
+	cfg, err := Parse([]byte(validConfig))
+	is.NoErr(err)
+
+	is.Equal(cfg.Database.URI, "postgresql://odk:secret@db:5432/odk?sslmode=disable")
+	is.Equal(cfg.Log.Level, "debug")
+	is.Equal(*cfg.Retry.MaxAttempts, 3) // default, as the variable is unset
+	is.Equal(*cfg.Retry.BaseDelay, 2*time.Second)
+
+	is.Equal(len(cfg.Endpoints), 3)
+	is.Equal(cfg.Endpoints[0].Headers["X-Source"], "central")
+	is.Equal(cfg.Endpoints[0].Auth.ApiKey, "my: key # not a comment")
+	is.Equal(cfg.Endpoints[1].Auth.Basic.Password, "")
+
+	is.Equal(len(cfg.Routes), 2)
+	is.Equal(cfg.Routes[0].Projects, []int{1})
+	is.Equal(cfg.Routes[1].Endpoints, []string{"project-1", "project-2"})
+}
+
+func TestParseJson(t *testing.T) {
+	is := is.New(t)
+
+	cfg, err := Parse([]byte(`{
+		"endpoints": [{"name": "a", "url": "http://localhost:8000/webhook"}],
+		"routes": [{"events": ["form.*"], "endpoints": ["a"]}]
+	}`))
+	is.NoErr(err)
+	is.Equal(cfg.Endpoints[0].URL, "http://localhost:8000/webhook")
+	is.Equal(cfg.Routes[0].Events, []string{"form.*"})
+}
+
+func TestParseErrors(t *testing.T) {
+	is := is.New(t)
+
+	invalid := strings.Join([]string{
+		`log:`,                                 // 1
+		`  level: verbose`,                     // 2
+		`retry:`,                               // 3
+		`  maxAttempts: 0`,                     // 4
+		`endpoints:`,                           // 5
+		`  - name: a`,                          // 6
+		`    url: ftp://example.com`,           // 7
+		`    auth:`,                            // 8
+		`      apiKey: key`,                    // 9
+		`      bearerToken: token`,             // 10
+		`  - name: a`,                          // 11
+		`    urll: https://example.com`,        // 12
+		`routes:`,                              // 13
+		`  - events: ["submission.*"]`,         // 14
+		`    endpoints: [a, b]`,                // 15
+		`  - events: ["${TEST_CONFIG_UNSET}"]`, // 16
+	}, "\n")
+
+	_, err := Parse([]byte(invalid))
+	is.True(err != nil)
+
+	// All problems are reported, with line numbers
+	expected := []string{
+		`line 16: environment variable TEST_CONFIG_UNSET is not set`,
+		`line 12: unknown field "urll" in endpoints[1]`,
+		`line 2: log.level: must be one of debug, info, warn or error, got "verbose"`,
+		`line 4: retry.maxAttempts: must be at least 1`,
+		`line 7: endpoints[0].url: must be an http, https, file, stdout, exec, kafka, mqtt, mqtts, nats, redis or rediss url, got "ftp://example.com"`,
+		`line 8: endpoints[0].auth: only one of apiKey, bearerToken, basic or oauth2 can be set`,
+		`line 11: endpoints[1].name: duplicate endpoint name "a"`,
+		`line 11: endpoints[1].url: is required`,
+		`line 15: routes[0].endpoints[1]: unknown endpoint "b"`,
+		`line 16: routes[1].events[0]: invalid event pattern ""`,
+		`line 16: routes[1].endpoints: at least one endpoint is required`,
+	}
+	is.Equal(strings.Split(err.Error(), "\n"), expected)
+}
+
+func TestParseAuthErrors(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(strings.Join([]string{
+		`endpoints:`,
+		`  - name: a`,
+		`    url: https://a.example.com`,
+		`    auth: {}`,
+		`  - name: b`,
+		`    url: https://b.example.com`,
+		`    auth:`,
+		`      signingSecrets: ["whsec_not base64!"]`,
+		`  - name: c`,
+		`    url: https://c.example.com`,
+		`    auth:`,
+		`      apiKey: key`,
+		`      oauth2:`,
+		`        tokenUrl: auth.example.com/token`,
+	}, "\n")))
+	is.True(err != nil)
+
+	errs := strings.Split(err.Error(), "\n")
+	is.Equal(len(errs), 6)
+	is.Equal(errs[0], "line 4: endpoints[0].auth: one of apiKey, bearerToken, basic, oauth2 or signingSecrets is required")
+	is.True(strings.HasPrefix(errs[1], "line 8: endpoints[1].auth.signingSecrets[0]: invalid whsec_ signing secret"))
+	is.Equal(errs[2:], []string{
+		`line 14: endpoints[2].auth.oauth2.tokenUrl: must be an http or https url, got "auth.example.com/token"`,
+		`line 13: endpoints[2].auth.oauth2.clientId: is required`,
+		`line 13: endpoints[2].auth.oauth2.clientSecret: is required`,
+		`line 11: endpoints[2].auth: only one of apiKey, bearerToken, basic or oauth2 can be set`,
+	})
+}
+
+func TestParseSinkErrors(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(strings.Join([]string{
+		`endpoints:`,
+		`  - name: a`,
+		`    url: file:///tmp/events.jsonl`,
+		`    args: [-v]`,
+		`    auth:`,
+		`      apiKey: key`,
+		`  - name: b`,
+		`    url: "exec:"`,
+		`  - name: c`,
+		`    url: nats://localhost:4222/odk.{unknown}`,
+		`  - name: d`,
+		`    url: nats://localhost:4222/odk?jetstream=maybe`,
+	}, "\n")))
+	is.True(err != nil)
+	is.Equal(strings.Split(err.Error(), "\n"), []string{
+		`line 4: endpoints[0].args: is only used for exec urls`,
+		`line 5: endpoints[0].auth: is only used for http and https urls`,
+		`line 8: endpoints[1].url: must be an http, https, file, stdout, exec, kafka, mqtt, mqtts, nats, redis or rediss url, got "exec:"`,
+		`line 10: endpoints[2].url: unknown field {unknown} in topic "odk.{unknown}"`,
+		`line 12: endpoints[3].url: invalid jetstream value "maybe"`,
+	})
+}
+
+func TestSinkEndpoints(t *testing.T) {
+	is := is.New(t)
+
+	cfg, err := Parse([]byte(strings.Join([]string{
+		`endpoints:`,
+		`  - name: file`,
+		`    url: file:///tmp/events.jsonl`,
+		`  - name: handler`,
+		`    url: exec:/usr/local/bin/handle-event`,
+		`    args: [--verbose]`,
+		`  - name: kafka`,
+		`    url: kafka://localhost:9092/odk.{projectId}.{type}`,
+		`routes:`,
+		`  - endpoints: [file, handler, kafka]`,
+	}, "\n")))
+	is.NoErr(err)
+
+	endpoints, err := cfg.WebhookEndpoints(webhook.DefaultRetryPolicy())
+	is.NoErr(err)
+	is.Equal(endpoints[0].Sink, webhook.FileSink{Path: "/tmp/events.jsonl"})
+	is.Equal(endpoints[1].Sink, webhook.ExecSink{Command: "/usr/local/bin/handle-event", Args: []string{"--verbose"}})
+	is.Equal(endpoints[1].RetryPolicy.MaxAttempts, webhook.DefaultRetryPolicy().MaxAttempts)
+	is.True(endpoints[2].Sink == webhook.SinkForUrl("kafka://localhost:9092/odk.{projectId}.{type}")) // shared by url
+	is.Equal(endpoints[2].Client, nil)
+}
+
+func TestParseTypeErrors(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte("retry:\n  maxAttempts: many\n  baseDelay: soon\n"))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "line 2: cannot unmarshal !!str `many` into int"))
+	is.True(strings.Contains(err.Error(), "line 3:"))
+
+	_, err = Parse([]byte("endpoints: [\n"))
+	is.True(err != nil) // invalid syntax
+
+	_, err = Parse([]byte(""))
+	is.True(err != nil)
+}
+
+func TestLoad(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte("endpoints:\n  - name: a\n"), 0o600)
+	is.NoErr(err)
+
+	_, err = Load(path)
+	is.True(err != nil)
+	is.True(strings.HasPrefix(err.Error(), "invalid config "+path))
+	is.True(strings.Contains(err.Error(), "line 2: endpoints[0].url: is required"))
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	is.True(err != nil)
+}
+
+func TestHTTPApply(t *testing.T) {
+	is := is.New(t)
+	t.Setenv("TEST_CONFIG_DB_PASSWORD", "secret")
+	t.Setenv("TEST_CONFIG_API_KEY", "key")
+
+	cfg, err := Parse([]byte(validConfig))
+	is.NoErr(err)
+
+	defaultOptions := cfg.HTTP.Apply(webhook.ClientOptions{})
+	is.Equal(defaultOptions.Timeout, 30*time.Second)
+	is.Equal(defaultOptions.MaxIdleConnsPerHost, 32)
+	is.Equal(defaultOptions.Proxy, nil) // from the environment
+
+	options := cfg.Endpoints[1].HTTP.Apply(defaultOptions)
+	is.Equal(options.Timeout, 5*time.Second)
+	is.Equal(options.MaxIdleConnsPerHost, 32) // not overridden
+	is.True(options.DisableHTTP2)
+	proxyUrl, err := options.Proxy(&http.Request{})
+	is.NoErr(err)
+	is.Equal(proxyUrl.String(), "http://proxy.example.com:3128")
+
+	// An empty proxy is no proxy, even if set in the environment
+	noProxy := ""
+	options = (&HTTP{Proxy: &noProxy}).Apply(defaultOptions)
+	proxyUrl, err = options.Proxy(&http.Request{})
+	is.NoErr(err)
+	is.Equal(proxyUrl, nil)
+}
+
+func TestParseHTTPErrors(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(strings.Join([]string{
+		`http:`,
+		`  timeout: -1s`,
+		`  maxConnsPerHost: -1`,
+		`endpoints:`,
+		`  - name: a`,
+		`    url: https://a.example.com`,
+		`    http:`,
+		`      proxy: proxy.example.com`,
+	}, "\n")))
+	is.True(err != nil)
+
+	is.Equal(strings.Split(err.Error(), "\n"), []string{
+		`line 2: http.timeout: must not be negative`,
+		`line 3: http.maxConnsPerHost: must not be negative`,
+		`line 8: endpoints[0].http.proxy: must be an http, https or socks5 url, got "proxy.example.com"`,
+	})
+}
+
+func TestParseDeliveryErrors(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte("delivery:\n  concurrency: -1\n  overflow: drop-all\n"))
+	is.True(err != nil)
+	is.Equal(strings.Split(err.Error(), "\n"), []string{
+		`line 2: delivery.concurrency: must not be negative`,
+		`line 3: delivery.overflow: must be one of block, drop-newest, drop-oldest or spill, got "drop-all"`,
+	})
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	is := is.New(t)
+	t.Setenv("TEST_CONFIG_DB_PASSWORD", "secret")
+	t.Setenv("TEST_CONFIG_API_KEY", "key")
+
+	cfg, err := Parse([]byte(validConfig))
+	is.NoErr(err)
+
+	defaultPolicy := cfg.Retry.Apply(webhook.DefaultRetryPolicy())
+	is.Equal(defaultPolicy.MaxAttempts, 3)
+	is.Equal(defaultPolicy.BaseDelay, 2*time.Second)
+	is.Equal(defaultPolicy.MaxDelay, webhook.DefaultRetryPolicy().MaxDelay) // not overridden
+
+	endpoints, err := cfg.WebhookEndpoints(defaultPolicy)
+	is.NoErr(err)
+	is.Equal(len(endpoints), 3)
+	is.Equal(endpoints[0].Name, "project-1")
+	is.Equal(endpoints[0].Auth.ApiKey, "key")
+	is.Equal(len(endpoints[0].Auth.SigningSecrets), 2)
+	is.Equal(endpoints[0].RetryPolicy.MaxAttempts, 3)
+	is.Equal(endpoints[1].Auth.Basic.Username, "odk")
+	is.Equal(endpoints[1].RetryPolicy.MaxAttempts, 10)
+	is.Equal(endpoints[1].RetryPolicy.BaseDelay, 2*time.Second)
+	is.Equal(endpoints[2].Auth.OAuth2.TokenURL, "https://auth.example.com/oauth/token")
+	is.Equal(endpoints[2].Auth.OAuth2.ClientSecret, "secret")
+	is.Equal(endpoints[2].Auth.OAuth2.Scopes, []string{"webhooks"})
+	is.Equal(endpoints[2].Auth.OAuth2.Params["audience"], "https://project3.example.com")
+
+	routes := cfg.RouterRoutes()
+	is.Equal(routes[0].ProjectIds, []int{1})
+	is.Equal(routes[1].Datasets, []string{"trees"})
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	stdlog "log"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// writeClientCert writes a CA and a client certificate signed by it to dir,
+// returning the CA pool to verify clients with
+func writeClientCert(t *testing.T, dir string) *x509.CertPool {
+	is := is.New(t)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.NoErr(err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	is.NoErr(err)
+	ca, err := x509.ParseCertificate(caDer)
+	is.NoErr(err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.NoErr(err)
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "central-webhook"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDer, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	is.NoErr(err)
+	clientKeyDer, err := x509.MarshalECPrivateKey(clientKey)
+	is.NoErr(err)
+
+	writePem := func(name, blockType string, der []byte) {
+		data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+		is.NoErr(os.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+	writePem("client.pem", "CERTIFICATE", clientDer)
+	writePem("client-key.pem", "EC PRIVATE KEY", clientKeyDer)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool
+}
+
+func TestMutualTLS(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	dir := t.TempDir()
+	clientCAs := writeClientCert(t, dir)
+
+	// A server that requires a client certificate, with its own CA
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.Config.ErrorLog = stdlog.New(io.Discard, "", 0) // expected handshake errors
+	server.StartTLS()
+	defer server.Close()
+
+	serverCa := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	is.NoErr(os.WriteFile(filepath.Join(dir, "ca.pem"), serverCa, 0o600))
+
+	cfg, err := Parse([]byte(strings.Join([]string{
+		`endpoints:`,
+		`  - name: mtls`,
+		`    url: ` + server.URL,
+		`    tls:`,
+		`      caFile: ` + filepath.Join(dir, "ca.pem"),
+		`      certFile: ` + filepath.Join(dir, "client.pem"),
+		`      keyFile: ` + filepath.Join(dir, "client-key.pem"),
+		`      serverName: example.com`, // in the httptest certificate
+		`      minVersion: "1.3"`,
+		`  - name: no-cert`,
+		`    url: ` + server.URL,
+		`    tls:`,
+		`      caFile: ` + filepath.Join(dir, "ca.pem"),
+		`  - name: no-ca`,
+		`    url: ` + server.URL,
+	}, "\n")))
+	is.NoErr(err)
+
+	endpoints, err := cfg.WebhookEndpoints(webhook.RetryPolicy{MaxAttempts: 1})
+	is.NoErr(err)
+	event := parser.ProcessedEvent{ID: "abc", Type: "submission.create"}
+
+	result, err := webhook.Send(log, context.Background(), endpoints[0], event)
+	is.NoErr(err)
+	is.Equal(result.StatusCode, http.StatusOK)
+
+	// The server rejects requests without a client certificate
+	_, err = webhook.Send(log, context.Background(), endpoints[1], event)
+	is.True(err != nil)
+
+	// The server certificate is not trusted by the system CAs
+	_, err = webhook.Send(log, context.Background(), endpoints[2], event)
+	is.True(err != nil)
+}
+
+func TestParseTLSErrors(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("not a certificate"), 0o600))
+
+	_, err := Parse([]byte(strings.Join([]string{
+		`endpoints:`,
+		`  - name: a`,
+		`    url: https://a.example.com`,
+		`    tls:`,
+		`      certFile: client.pem`,
+		`      minVersion: "1.4"`,
+		`  - name: b`,
+		`    url: https://b.example.com`,
+		`    tls:`,
+		`      caFile: ` + filepath.Join(dir, "ca.pem"),
+	}, "\n")))
+	is.True(err != nil)
+
+	is.Equal(strings.Split(err.Error(), "\n"), []string{
+		`line 6: endpoints[0].tls.minVersion: must be one of 1.0, 1.1, 1.2 or 1.3, got "1.4"`,
+		`line 4: endpoints[0].tls.keyFile: is required with certFile`,
+		`line 9: endpoints[1].tls: no PEM certificates found in CA file ` + filepath.Join(dir, "ca.pem"),
+	})
+}
@@ -0,0 +1,390 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hotosm/central-webhook/db"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+var configType = reflect.TypeOf(Config{})
+
+// envPattern matches ${VAR} and ${VAR:-default}
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// interpolate replaces environment variables in all scalar values,
+// returning an error for each variable that is unset without a default
+func interpolate(node *yaml.Node) []error {
+	var errs []error
+
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+		node.Value = envPattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+			groups := envPattern.FindStringSubmatch(match)
+			name, fallback := groups[1], groups[2]
+			if value := os.Getenv(name); value != "" {
+				return value
+			}
+			if !strings.Contains(match, ":-") {
+				errs = append(errs, fmt.Errorf("line %d: environment variable %s is not set", node.Line, name))
+			}
+			return fallback
+		})
+		// Resolve the type again, e.g. so ${MAX_ATTEMPTS} can be an int
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	}
+
+	for _, child := range node.Content {
+		errs = append(errs, interpolate(child)...)
+	}
+	return errs
+}
+
+// checkFields returns an error for each mapping key that is not a field of
+// the type, as yaml.Node.Decode silently ignores unknown fields
+func checkFields(node *yaml.Node, nodePath string, t reflect.Type) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var errs []error
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			fields[name] = t.Field(i).Type
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, fmt.Errorf("line %d: unknown field %q%s", key.Line, key.Value, in(nodePath)))
+				continue
+			}
+			errs = append(errs, checkFields(value, joinPath(nodePath, key.Value), fieldType)...)
+		}
+
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			errs = append(errs, checkFields(item, fmt.Sprintf("%s[%d]", nodePath, i), t.Elem())...)
+		}
+	}
+	return errs
+}
+
+// nodeLines returns the line of each value in the document, by path,
+// e.g. "endpoints[0].url"
+func nodeLines(node *yaml.Node) map[string]int {
+	lines := map[string]int{"": node.Line}
+
+	var walk func(node *yaml.Node, nodePath string)
+	walk = func(node *yaml.Node, nodePath string) {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				childPath := joinPath(nodePath, node.Content[i].Value)
+				lines[childPath] = node.Content[i].Line
+				walk(node.Content[i+1], childPath)
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				childPath := fmt.Sprintf("%s[%d]", nodePath, i)
+				lines[childPath] = item.Line
+				walk(item, childPath)
+			}
+		}
+	}
+	walk(node, "")
+
+	return lines
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func in(nodePath string) string {
+	if nodePath == "" {
+		return ""
+	}
+	return " in " + nodePath
+}
+
+// validator collects validation errors, with the line of each value
+type validator struct {
+	lines map[string]int
+	errs  []error
+}
+
+// errorf adds an error for the value at the path, using the line of the
+// closest parent for values that are missing
+func (v *validator) errorf(nodePath string, format string, args ...interface{}) {
+	line, ok := v.lines[nodePath]
+	for parent := nodePath; !ok && parent != ""; {
+		if i := strings.LastIndexAny(parent, ".["); i >= 0 {
+			parent = parent[:i]
+		} else {
+			parent = ""
+		}
+		line, ok = v.lines[parent]
+	}
+	v.errs = append(v.errs, fmt.Errorf("line %d: %s: %s", line, nodePath, fmt.Sprintf(format, args...)))
+}
+
+// validate checks the decoded config values
+func (cfg *Config) validate(lines map[string]int) []error {
+	v := &validator{lines: lines}
+
+	if level := strings.ToLower(cfg.Log.Level); level != "" && !slices.Contains([]string{"debug", "info", "warn", "error"}, level) {
+		v.errorf("log.level", "must be one of debug, info, warn or error, got %q", cfg.Log.Level)
+	}
+	if format := strings.ToLower(cfg.Log.Format); format != "" && format != "json" && format != "text" {
+		v.errorf("log.format", "must be json or text, got %q", cfg.Log.Format)
+	}
+
+	if cfg.Delivery.Concurrency < 0 {
+		v.errorf("delivery.concurrency", "must not be negative")
+	}
+	if cfg.Delivery.BufferSize < 0 {
+		v.errorf("delivery.bufferSize", "must not be negative")
+	}
+	if overflow := cfg.Delivery.Overflow; overflow != "" && !slices.Contains(db.OverflowPolicies, db.OverflowPolicy(overflow)) {
+		v.errorf("delivery.overflow", "must be one of block, drop-newest, drop-oldest or spill, got %q", overflow)
+	}
+	cfg.Retry.validate(v, "retry")
+	cfg.HTTP.validate(v, "http")
+
+	names := map[string]bool{}
+	for i, endpoint := range cfg.Endpoints {
+		endpointPath := fmt.Sprintf("endpoints[%d]", i)
+
+		if endpoint.Name == "" {
+			v.errorf(endpointPath+".name", "is required")
+		} else if names[endpoint.Name] {
+			v.errorf(endpointPath+".name", "duplicate endpoint name %q", endpoint.Name)
+		} else {
+			names[endpoint.Name] = true
+		}
+
+		sink, err := webhook.ParseSink(endpoint.URL)
+		if endpoint.URL == "" {
+			v.errorf(endpointPath+".url", "is required")
+		} else if err != nil {
+			v.errorf(endpointPath+".url", "%v", err)
+		} else if !isHttpUrl(endpoint.URL) && sink == nil {
+			v.errorf(endpointPath+".url", "must be an %s url, got %q", orList(webhook.SinkSchemes()), endpoint.URL)
+		}
+		if _, ok := sink.(webhook.ExecSink); !ok && endpoint.Args != nil {
+			v.errorf(endpointPath+".args", "is only used for exec urls")
+		}
+		if sink != nil {
+			httpOnly := []struct {
+				field string
+				set   bool
+			}{
+				{"headers", endpoint.Headers != nil},
+				{"auth", endpoint.Auth != nil},
+				{"tls", endpoint.TLS != nil},
+				{"http", endpoint.HTTP != nil},
+			}
+			for _, option := range httpOnly {
+				if option.set {
+					v.errorf(endpointPath+"."+option.field, "is only used for http and https urls")
+				}
+			}
+		}
+
+		for key := range endpoint.Headers {
+			if key == "" || strings.ContainsAny(key, " :\r\n") {
+				v.errorf(endpointPath+".headers", "invalid header name %q", key)
+			}
+		}
+
+		if auth := endpoint.Auth; auth != nil {
+			authPath := endpointPath + ".auth"
+			methods := 0
+			if auth.ApiKey != "" {
+				methods++
+			}
+			if auth.BearerToken != "" {
+				methods++
+			}
+			if auth.Basic != nil {
+				methods++
+				if auth.Basic.Username == "" {
+					v.errorf(authPath+".basic.username", "is required")
+				}
+			}
+			if oauth := auth.OAuth2; oauth != nil {
+				methods++
+				if oauth.TokenURL == "" {
+					v.errorf(authPath+".oauth2.tokenUrl", "is required")
+				} else if !isHttpUrl(oauth.TokenURL) {
+					v.errorf(authPath+".oauth2.tokenUrl", "must be an http or https url, got %q", oauth.TokenURL)
+				}
+				if oauth.ClientId == "" {
+					v.errorf(authPath+".oauth2.clientId", "is required")
+				}
+				if oauth.ClientSecret == "" {
+					v.errorf(authPath+".oauth2.clientSecret", "is required")
+				}
+			}
+			if methods > 1 {
+				v.errorf(authPath, "only one of apiKey, bearerToken, basic or oauth2 can be set")
+			}
+			if methods == 0 && len(auth.SigningSecrets) == 0 {
+				v.errorf(authPath, "one of apiKey, bearerToken, basic, oauth2 or signingSecrets is required")
+			}
+			for j, secret := range auth.SigningSecrets {
+				if err := webhook.ValidateSigningSecret(secret); err != nil {
+					v.errorf(fmt.Sprintf("%s.signingSecrets[%d]", authPath, j), "%v", err)
+				}
+			}
+		}
+
+		endpoint.TLS.validate(v, endpointPath+".tls")
+		endpoint.HTTP.validate(v, endpointPath+".http")
+		endpoint.Retry.validate(v, endpointPath+".retry")
+	}
+
+	for i, route := range cfg.Routes {
+		routePath := fmt.Sprintf("routes[%d]", i)
+
+		for j, event := range route.Events {
+			if _, err := path.Match(event, ""); err != nil || event == "" {
+				v.errorf(fmt.Sprintf("%s.events[%d]", routePath, j), "invalid event pattern %q", event)
+			}
+		}
+		for j, projectId := range route.Projects {
+			if projectId < 1 {
+				v.errorf(fmt.Sprintf("%s.projects[%d]", routePath, j), "invalid project id %d", projectId)
+			}
+		}
+
+		if len(route.Endpoints) == 0 {
+			v.errorf(routePath+".endpoints", "at least one endpoint is required")
+		}
+		for j, name := range route.Endpoints {
+			if !names[name] {
+				v.errorf(fmt.Sprintf("%s.endpoints[%d]", routePath, j), "unknown endpoint %q", name)
+			}
+		}
+	}
+
+	return v.errs
+}
+
+// validate checks the retry values that are set
+func (retry *Retry) validate(v *validator, retryPath string) {
+	if retry == nil {
+		return
+	}
+	if retry.MaxAttempts != nil && *retry.MaxAttempts < 1 {
+		v.errorf(retryPath+".maxAttempts", "must be at least 1")
+	}
+	if retry.BaseDelay != nil && *retry.BaseDelay < 0 {
+		v.errorf(retryPath+".baseDelay", "must not be negative")
+	}
+	if retry.MaxDelay != nil && *retry.MaxDelay < 0 {
+		v.errorf(retryPath+".maxDelay", "must not be negative")
+	}
+	if retry.Jitter != nil && (*retry.Jitter < 0 || *retry.Jitter > 1) {
+		v.errorf(retryPath+".jitter", "must be between 0 and 1")
+	}
+	for i, code := range retry.RetryableStatusCodes {
+		if code < 100 || code > 599 {
+			v.errorf(fmt.Sprintf("%s.retryableStatusCodes[%d]", retryPath, i), "invalid status code %d", code)
+		}
+	}
+}
+
+// validate checks the HTTP client values that are set
+func (h *HTTP) validate(v *validator, httpPath string) {
+	if h == nil {
+		return
+	}
+	durations := map[string]*time.Duration{
+		"timeout":               h.Timeout,
+		"connectTimeout":        h.ConnectTimeout,
+		"tlsHandshakeTimeout":   h.TLSHandshakeTimeout,
+		"responseHeaderTimeout": h.ResponseHeaderTimeout,
+		"idleConnTimeout":       h.IdleConnTimeout,
+	}
+	for _, name := range slices.Sorted(maps.Keys(durations)) {
+		if d := durations[name]; d != nil && *d < 0 {
+			v.errorf(httpPath+"."+name, "must not be negative")
+		}
+	}
+	counts := map[string]*int{
+		"maxIdleConns":        h.MaxIdleConns,
+		"maxIdleConnsPerHost": h.MaxIdleConnsPerHost,
+		"maxConnsPerHost":     h.MaxConnsPerHost,
+	}
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		if n := counts[name]; n != nil && *n < 0 {
+			v.errorf(httpPath+"."+name, "must not be negative")
+		}
+	}
+	if h.Proxy != nil && *h.Proxy != "" {
+		u, err := url.Parse(*h.Proxy)
+		if err != nil || !slices.Contains([]string{"http", "https", "socks5"}, u.Scheme) || u.Host == "" {
+			v.errorf(httpPath+".proxy", "must be an http, https or socks5 url, got %q", *h.Proxy)
+		}
+	}
+}
+
+// validate checks the TLS values, and that the certificate files are valid
+func (t *TLS) validate(v *validator, tlsPath string) {
+	if t == nil {
+		return
+	}
+	valid := true
+	if _, ok := tlsVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+		v.errorf(tlsPath+".minVersion", "must be one of 1.0, 1.1, 1.2 or 1.3, got %q", t.MinVersion)
+		valid = false
+	}
+	if t.CertFile != "" && t.KeyFile == "" {
+		v.errorf(tlsPath+".keyFile", "is required with certFile")
+		valid = false
+	}
+	if t.KeyFile != "" && t.CertFile == "" {
+		v.errorf(tlsPath+".certFile", "is required with keyFile")
+		valid = false
+	}
+	if valid {
+		if _, err := t.Config(); err != nil {
+			v.errorf(tlsPath, "%v", err)
+		}
+	}
+}
+
+// orList joins the values as a list, e.g. "a, b or c"
+func orList(values []string) string {
+	if len(values) < 2 {
+		return strings.Join(values, "")
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
+
+// isHttpUrl returns true if the value is an absolute http or https url
+func isHttpUrl(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
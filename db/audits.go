@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Audit is a row of the audits table, with the payload in the same format
+// as the trigger notifications, to be enriched with EnrichEvent
+type Audit struct {
+	ID       int64
+	LoggedAt time.Time
+	Payload  []byte
+}
+
+// AuditFilter selects audit rows to replay. Zero values do not filter.
+type AuditFilter struct {
+	Since   time.Time // Logged at or after
+	Until   time.Time // Logged before
+	SinceId int64     // With an id at or after
+	UntilId int64     // With an id at or before
+	Actions []string  // Only these actions, else all supported actions
+}
+
+// SupportedActions returns the audit actions that trigger an event
+func SupportedActions() []string {
+	return slices.Clone(supportedActions)
+}
+
+// ListAudits returns up to `limit` audit rows with a supported action that
+// match the filter, with an id greater than afterId, oldest first. Pass the
+// id of the last row as afterId to get the next page.
+func ListAudits(ctx context.Context, dbPool *pgxpool.Pool, filter AuditFilter, afterId int64, limit int) ([]Audit, error) {
+	actions := slices.DeleteFunc(slices.Clone(filter.Actions), func(action string) bool {
+		return !slices.Contains(supportedActions, action)
+	})
+	if len(filter.Actions) == 0 {
+		actions = supportedActions
+	}
+
+	var since, until *time.Time
+	if !filter.Since.IsZero() {
+		since = &filter.Since
+	}
+	if !filter.Until.IsZero() {
+		until = &filter.Until
+	}
+
+	// The payload matches the notification from the new_audit_log trigger
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, "loggedAt", jsonb_set(to_jsonb(a.*), '{dml_action}', '"INSERT"')::text
+		FROM audits a
+		WHERE id > $1
+			AND action = ANY($2)
+			AND ($3::timestamptz IS NULL OR "loggedAt" >= $3)
+			AND ($4::timestamptz IS NULL OR "loggedAt" < $4)
+			AND ($5::bigint = 0 OR id <= $5)
+		ORDER BY id
+		LIMIT $6;
+	`, max(afterId, filter.SinceId-1), actions, since, until, filter.UntilId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audits: %w", err)
+	}
+	defer rows.Close()
+
+	var audits []Audit
+	for rows.Next() {
+		var audit Audit
+		var payload string
+		if err := rows.Scan(&audit.ID, &audit.LoggedAt, &payload); err != nil {
+			return nil, fmt.Errorf("failed to scan audit row: %w", err)
+		}
+		audit.Payload = []byte(payload)
+		audits = append(audits, audit)
+	}
+
+	return audits, rows.Err()
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// Note: these tests assume you have a postgres server listening on db:5432
+// with username odk and password odk.
+//
+// The easiest way to ensure this is to run the tests with docker compose:
+// docker compose run --rm webhook
+
+func TestListAudits(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	conn, err := pool.Acquire(ctx)
+	is.NoErr(err)
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `DROP TABLE IF EXISTS audits CASCADE;`)
+	is.NoErr(err)
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE audits (
+			id serial PRIMARY KEY,
+			"actorId" int,
+			action varchar,
+			"acteeId" varchar,
+			details jsonb,
+			"loggedAt" timestamptz
+		);
+	`)
+	is.NoErr(err)
+	defer conn.Exec(ctx, `DROP TABLE IF EXISTS audits CASCADE;`)
+
+	_, err = conn.Exec(ctx, `
+		INSERT INTO audits ("actorId", action, details, "loggedAt")
+		VALUES
+			(5, 'submission.create', '{"submissionDefId": 1}', '2026-10-01T10:00:00Z'),
+			(5, 'user.session.create', '{}', '2026-10-01T11:00:00Z'),
+			(5, 'entity.create', '{"entityDefId": 1}', '2026-10-02T10:00:00Z'),
+			(5, 'submission.create', '{"submissionDefId": 2}', '2026-10-03T10:00:00Z');
+	`)
+	is.NoErr(err)
+
+	ids := func(audits []Audit) []int64 {
+		var ids []int64
+		for _, audit := range audits {
+			ids = append(ids, audit.ID)
+		}
+		return ids
+	}
+
+	// Only supported actions, oldest first
+	audits, err := ListAudits(ctx, pool, AuditFilter{}, 0, 10)
+	is.NoErr(err)
+	is.Equal(ids(audits), []int64{1, 3, 4})
+	is.Equal(audits[0].LoggedAt, time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC).Local())
+
+	// The payload matches the trigger notification
+	var payload map[string]interface{}
+	is.NoErr(json.Unmarshal(audits[0].Payload, &payload))
+	is.Equal(payload["action"], "submission.create")
+	is.Equal(payload["dml_action"], "INSERT")
+	is.Equal(payload["details"], map[string]interface{}{"submissionDefId": float64(1)})
+
+	// Pages
+	audits, err = ListAudits(ctx, pool, AuditFilter{}, 1, 1)
+	is.NoErr(err)
+	is.Equal(ids(audits), []int64{3})
+
+	// By time, with until exclusive
+	audits, err = ListAudits(ctx, pool, AuditFilter{
+		Since: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		Until: time.Date(2026, 10, 3, 10, 0, 0, 0, time.UTC),
+	}, 0, 10)
+	is.NoErr(err)
+	is.Equal(ids(audits), []int64{3})
+
+	// By id, inclusive
+	audits, err = ListAudits(ctx, pool, AuditFilter{SinceId: 3, UntilId: 3}, 0, 10)
+	is.NoErr(err)
+	is.Equal(ids(audits), []int64{3})
+
+	// By action, ignoring unsupported actions
+	audits, err = ListAudits(ctx, pool, AuditFilter{Actions: []string{"submission.create", "user.session.create"}}, 0, 10)
+	is.NoErr(err)
+	is.Equal(ids(audits), []int64{1, 4})
+}
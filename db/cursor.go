@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The cursor table holds the last audit event processed by the webhook
+// service, so it can catch up from the audits table after a restart.
+const createCursorSQL = `
+	CREATE TABLE IF NOT EXISTS webhook_cursor (
+		name text PRIMARY KEY,
+		"auditId" bigint NOT NULL,
+		"loggedAt" timestamptz,
+		"updatedAt" timestamptz NOT NULL DEFAULT now()
+	);
+`
+
+// Cursor is the last audit event processed, by id and time logged
+type Cursor struct {
+	AuditId  int64
+	LoggedAt time.Time
+}
+
+// CreateCursorTable creates the cursor table, if it does not already exist
+func CreateCursorTable(ctx context.Context, dbPool *pgxpool.Pool) error {
+	if _, err := dbPool.Exec(ctx, createCursorSQL); err != nil {
+		return fmt.Errorf("failed to create cursor table: %w", err)
+	}
+	return nil
+}
+
+// GetCursor returns the named cursor, or nil if it was never saved
+func GetCursor(ctx context.Context, dbPool *pgxpool.Pool, name string) (*Cursor, error) {
+	var cursor Cursor
+	var loggedAt *time.Time
+	err := dbPool.QueryRow(ctx, `
+		SELECT "auditId", "loggedAt" FROM webhook_cursor WHERE name = $1;
+	`, name).Scan(&cursor.AuditId, &loggedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cursor %q: %w", name, err)
+	}
+	if loggedAt != nil {
+		cursor.LoggedAt = *loggedAt
+	}
+	return &cursor, nil
+}
+
+// SaveCursor saves the named cursor, unless it was already saved with a
+// later audit id, so the cursor only moves forward
+func SaveCursor(ctx context.Context, dbPool *pgxpool.Pool, name string, cursor Cursor) error {
+	var loggedAt *time.Time
+	if !cursor.LoggedAt.IsZero() {
+		loggedAt = &cursor.LoggedAt
+	}
+
+	_, err := dbPool.Exec(ctx, `
+		INSERT INTO webhook_cursor (name, "auditId", "loggedAt")
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE
+			SET "auditId" = EXCLUDED."auditId",
+				"loggedAt" = EXCLUDED."loggedAt",
+				"updatedAt" = now()
+			WHERE webhook_cursor."auditId" < EXCLUDED."auditId";
+	`, name, cursor.AuditId, loggedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save cursor %q: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// Note: these tests assume you have a postgres server listening on db:5432
+// with username odk and password odk.
+//
+// The easiest way to ensure this is to run the tests with docker compose:
+// docker compose run --rm webhook
+
+func TestCursor(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	_, err = pool.Exec(ctx, `DROP TABLE IF EXISTS webhook_cursor;`)
+	is.NoErr(err)
+	is.NoErr(CreateCursorTable(ctx, pool))
+
+	// Not saved yet
+	cursor, err := GetCursor(ctx, pool, "test")
+	is.NoErr(err)
+	is.Equal(cursor, nil)
+
+	loggedAt := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	is.NoErr(SaveCursor(ctx, pool, "test", Cursor{AuditId: 10, LoggedAt: loggedAt}))
+	cursor, err = GetCursor(ctx, pool, "test")
+	is.NoErr(err)
+	is.Equal(cursor.AuditId, int64(10))
+	is.True(cursor.LoggedAt.Equal(loggedAt))
+
+	// Only moves forward
+	is.NoErr(SaveCursor(ctx, pool, "test", Cursor{AuditId: 5}))
+	cursor, err = GetCursor(ctx, pool, "test")
+	is.NoErr(err)
+	is.Equal(cursor.AuditId, int64(10))
+
+	is.NoErr(SaveCursor(ctx, pool, "test", Cursor{AuditId: 12, LoggedAt: loggedAt.Add(time.Minute)}))
+	cursor, err = GetCursor(ctx, pool, "test")
+	is.NoErr(err)
+	is.Equal(cursor.AuditId, int64(12))
+	is.True(cursor.LoggedAt.Equal(loggedAt.Add(time.Minute)))
+}
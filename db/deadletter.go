@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The dead letter table holds events that could not be delivered to a
+// webhook after all retries, so they can be inspected and replayed later.
+const createDeadLetterSQL = `
+	CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+		id bigserial PRIMARY KEY,
+		"outboxId" bigint,
+		endpoint text NOT NULL,
+		"endpointName" text NOT NULL DEFAULT '',
+		"eventType" text NOT NULL,
+		event jsonb NOT NULL,
+		"statusCode" int NOT NULL DEFAULT 0,
+		"responseBody" text NOT NULL DEFAULT '',
+		attempts int NOT NULL DEFAULT 0,
+		error text NOT NULL DEFAULT '',
+		"createdAt" timestamptz NOT NULL DEFAULT now(),
+		"updatedAt" timestamptz NOT NULL DEFAULT now()
+	);
+
+	-- Added after the table was first created
+	ALTER TABLE webhook_dead_letters
+		ADD COLUMN IF NOT EXISTS "endpointName" text NOT NULL DEFAULT '';
+`
+
+// DeadLetter is an event that permanently failed delivery to a webhook endpoint
+type DeadLetter struct {
+	ID           int64
+	OutboxId     int64  // The originating outbox event, 0 if unknown
+	Endpoint     string // The webhook url the event was sent to, without the password
+	EndpointName string // The name of the configured endpoint, "" for route urls
+	EventType    string
+	Event        []byte // The JSON payload sent to the webhook
+	StatusCode   int    // The last response status code, 0 if no response
+	ResponseBody string // The last response body
+	Attempts     int
+	Error        string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// CreateDeadLetterTable creates the dead letter table, if it does not already exist
+func CreateDeadLetterTable(ctx context.Context, dbPool *pgxpool.Pool) error {
+	if _, err := dbPool.Exec(ctx, createDeadLetterSQL); err != nil {
+		return fmt.Errorf("failed to create dead letter table: %w", err)
+	}
+	return nil
+}
+
+// InsertDeadLetter stores a failed event, returning the new dead letter id
+func InsertDeadLetter(ctx context.Context, dbPool *pgxpool.Pool, deadLetter DeadLetter) (int64, error) {
+	var outboxId *int64
+	if deadLetter.OutboxId != 0 {
+		outboxId = &deadLetter.OutboxId
+	}
+
+	var id int64
+	err := dbPool.QueryRow(ctx, `
+		INSERT INTO webhook_dead_letters (
+			"outboxId", endpoint, "endpointName", "eventType", event, "statusCode", "responseBody", attempts, error
+		) VALUES ($1, $2, $3, $4, $5::jsonb, $6, $7, $8, $9)
+		RETURNING id;
+	`,
+		outboxId,
+		deadLetter.Endpoint,
+		deadLetter.EndpointName,
+		deadLetter.EventType,
+		string(deadLetter.Event),
+		deadLetter.StatusCode,
+		deadLetter.ResponseBody,
+		deadLetter.Attempts,
+		deadLetter.Error,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert dead letter: %w", err)
+	}
+	return id, nil
+}
+
+// ListDeadLetters returns up to `limit` dead letters, oldest first.
+// If ids are provided, only those dead letters are returned.
+func ListDeadLetters(ctx context.Context, dbPool *pgxpool.Pool, limit int, ids ...int64) ([]DeadLetter, error) {
+	if ids == nil {
+		ids = []int64{} // an empty array, rather than NULL
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT
+			id, COALESCE("outboxId", 0), endpoint, "endpointName", "eventType", event::text,
+			"statusCode", "responseBody", attempts, error, "createdAt", "updatedAt"
+		FROM webhook_dead_letters
+		WHERE cardinality($2::bigint[]) = 0 OR id = ANY($2::bigint[])
+		ORDER BY id
+		LIMIT $1;
+	`, limit, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var deadLetters []DeadLetter
+	for rows.Next() {
+		var deadLetter DeadLetter
+		var event string
+		err := rows.Scan(
+			&deadLetter.ID,
+			&deadLetter.OutboxId,
+			&deadLetter.Endpoint,
+			&deadLetter.EndpointName,
+			&deadLetter.EventType,
+			&event,
+			&deadLetter.StatusCode,
+			&deadLetter.ResponseBody,
+			&deadLetter.Attempts,
+			&deadLetter.Error,
+			&deadLetter.CreatedAt,
+			&deadLetter.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter row: %w", err)
+		}
+		deadLetter.Event = []byte(event)
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, rows.Err()
+}
+
+// UpdateDeadLetterAttempt records the outcome of a failed replay of a dead letter
+func UpdateDeadLetterAttempt(
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	id int64,
+	statusCode int,
+	responseBody string,
+	attempts int,
+	errMsg string,
+) error {
+	_, err := dbPool.Exec(ctx, `
+		UPDATE webhook_dead_letters
+		SET
+			"statusCode" = $2,
+			"responseBody" = $3,
+			attempts = attempts + $4,
+			error = $5,
+			"updatedAt" = now()
+		WHERE id = $1;
+	`, id, statusCode, responseBody, attempts, errMsg)
+	if err != nil {
+		return fmt.Errorf("failed to update dead letter %d: %w", id, err)
+	}
+	return nil
+}
+
+// DeleteDeadLetters removes the dead letters with the given ids, or all dead
+// letters if no ids are provided. The number of deleted rows is returned.
+func DeleteDeadLetters(ctx context.Context, dbPool *pgxpool.Pool, ids ...int64) (int64, error) {
+	if ids == nil {
+		ids = []int64{} // an empty array, rather than NULL
+	}
+
+	tag, err := dbPool.Exec(ctx, `
+		DELETE FROM webhook_dead_letters
+		WHERE cardinality($1::bigint[]) = 0 OR id = ANY($1::bigint[]);
+	`, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete dead letters: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
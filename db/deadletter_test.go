@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// Note: these tests assume you have a postgres server listening on db:5432
+// with username odk and password odk.
+//
+// The easiest way to ensure this is to run the tests with docker compose:
+// docker compose run --rm webhook
+
+func TestDeadLetters(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	// Start with an empty dead letter table
+	_, err = pool.Exec(ctx, `DROP TABLE IF EXISTS webhook_dead_letters;`)
+	is.NoErr(err)
+	err = CreateDeadLetterTable(ctx, pool)
+	is.NoErr(err)
+
+	// Insert dead letters
+	firstId, err := InsertDeadLetter(ctx, pool, DeadLetter{
+		OutboxId:     12,
+		Endpoint:     "https://example.com/webhook",
+		EndpointName: "example",
+		EventType:    "submission.create",
+		Event:        []byte(`{"type":"submission.create","id":"uuid:123","data":{"xml":"<data/>"}}`),
+		StatusCode:   502,
+		ResponseBody: "bad gateway",
+		Attempts:     5,
+		Error:        "webhook responded with status code 502",
+	})
+	is.NoErr(err)
+
+	secondId, err := InsertDeadLetter(ctx, pool, DeadLetter{
+		Endpoint:  "https://example.com/webhook",
+		EventType: "entity.update.version",
+		Event:     []byte(`{"type":"entity.update.version","id":"uuid:456","data":{}}`),
+		Attempts:  1,
+		Error:     "failed to send HTTP request",
+	})
+	is.NoErr(err)
+
+	// List all dead letters
+	deadLetters, err := ListDeadLetters(ctx, pool, 10)
+	is.NoErr(err)
+	is.Equal(len(deadLetters), 2)
+	is.Equal(deadLetters[0].ID, firstId)
+	is.Equal(deadLetters[0].OutboxId, int64(12))
+	is.Equal(deadLetters[0].EndpointName, "example")
+	is.Equal(deadLetters[0].StatusCode, 502)
+	is.Equal(deadLetters[0].ResponseBody, "bad gateway")
+	is.Equal(deadLetters[0].Attempts, 5)
+	is.Equal(deadLetters[1].OutboxId, int64(0))
+	is.Equal(deadLetters[1].EndpointName, "")
+
+	// List by id
+	deadLetters, err = ListDeadLetters(ctx, pool, 10, secondId)
+	is.NoErr(err)
+	is.Equal(len(deadLetters), 1)
+	is.Equal(deadLetters[0].EventType, "entity.update.version")
+
+	// Record a failed replay
+	err = UpdateDeadLetterAttempt(ctx, pool, secondId, 503, "unavailable", 3, "webhook responded with status code 503")
+	is.NoErr(err)
+	deadLetters, err = ListDeadLetters(ctx, pool, 10, secondId)
+	is.NoErr(err)
+	is.Equal(deadLetters[0].Attempts, 4)
+	is.Equal(deadLetters[0].StatusCode, 503)
+
+	// Delete by id, then delete all
+	deleted, err := DeleteDeadLetters(ctx, pool, firstId)
+	is.NoErr(err)
+	is.Equal(deleted, int64(1))
+
+	deleted, err = DeleteDeadLetters(ctx, pool)
+	is.NoErr(err)
+	is.Equal(deleted, int64(1))
+
+	deadLetters, err = ListDeadLetters(ctx, pool, 10)
+	is.NoErr(err)
+	is.Equal(len(deadLetters), 0)
+
+	// Cleanup
+	pool.Exec(ctx, `DROP TABLE IF EXISTS webhook_dead_letters;`)
+}
@@ -0,0 +1,435 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EnrichEvent adds the full data for an audit event, fetched from the database
+// by the ids in the event details. This is done here rather than in the
+// trigger, as pg_notify payloads are limited to 8000 bytes, and entity data or
+// submission XML is often larger than this.
+//
+// The enriched event JSON can then be parsed with parser.ParseEventJson.
+// Unsupported actions are returned unchanged.
+func EnrichEvent(ctx context.Context, dbPool *pgxpool.Pool, payload []byte) ([]byte, error) {
+	// Decode numbers as json.Number, so ids are passed through unchanged
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var event map[string]interface{}
+	if err := decoder.Decode(&event); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+
+	action, _ := event["action"].(string)
+	details, _ := event["details"].(map[string]interface{})
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+
+	switch action {
+	case "entity.update.version":
+		if _, err := addDatasetRef(ctx, dbPool, event, details); err != nil {
+			return nil, err
+		}
+
+		var data []byte
+		err := queryOptionalRow(ctx, dbPool, `
+			SELECT data FROM entity_defs WHERE id = $1;
+		`, details["entityDefId"], &data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch entity data: %w", err)
+		}
+		event["data"] = json.RawMessage(jsonOrNull(data))
+
+	case "entity.create", "entity.update.resolve":
+		if _, err := addDatasetRef(ctx, dbPool, event, details); err != nil {
+			return nil, err
+		}
+
+		var data []byte
+		err := queryOptionalRow(ctx, dbPool, `
+			SELECT data FROM entity_defs WHERE id = $1;
+		`, details["entityDefId"], &data)
+		if err == nil && data == nil {
+			// Fall back to the current version, if the version is not referenced
+			err = queryOptionalRow(ctx, dbPool, `
+				SELECT data FROM entity_defs WHERE "entityId" = $1 AND current;
+			`, details["entityId"], &data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch entity data: %w", err)
+		}
+		event["data"] = json.RawMessage(jsonOrNull(data))
+
+	case "entity.delete", "entity.restore":
+		// Only the entity uuid is recorded, so add the dataset name
+		entity, _ := details["entity"].(map[string]interface{})
+		if entity == nil {
+			entity = map[string]interface{}{}
+		}
+		dataset, err := addDatasetRef(ctx, dbPool, event, details)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := entity["dataset"]; !ok && dataset != "" {
+			entity["dataset"] = dataset
+		}
+		details["entity"] = entity
+		event["details"] = details
+
+	case "entity.bulk.create":
+		dataset, err := addDatasetRef(ctx, dbPool, event, details)
+		if err != nil {
+			return nil, err
+		}
+		details["dataset"] = dataset
+
+		entities, err := sourceEntities(ctx, dbPool, details["sourceId"])
+		if err != nil {
+			return nil, err
+		}
+		event["data"] = map[string]interface{}{
+			"count":    details["count"],
+			"source":   details["source"],
+			"entities": entities,
+		}
+
+	case "submission.create":
+		if err := addFormRef(ctx, dbPool, event, details); err != nil {
+			return nil, err
+		}
+
+		var xml *string
+		err := queryOptionalRow(ctx, dbPool, `
+			SELECT xml FROM submission_defs WHERE id = $1;
+		`, details["submissionDefId"], &xml)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch submission xml: %w", err)
+		}
+		if xml != nil {
+			event["data"] = map[string]interface{}{"xml": *xml}
+		} else {
+			event["data"] = nil
+		}
+
+	case "submission.update":
+		if err := addFormRef(ctx, dbPool, event, details); err != nil {
+			return nil, err
+		}
+
+		var instanceId *string
+		err := queryOptionalRow(ctx, dbPool, `
+			SELECT "instanceId"::text FROM submission_defs WHERE id = $1;
+		`, details["submissionDefId"], &instanceId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch submission instanceId: %w", err)
+		}
+
+		// Move 'reviewState' from 'details' into 'data', and add the instanceId
+		event["data"] = map[string]interface{}{"reviewState": details["reviewState"]}
+		delete(details, "reviewState")
+		if instanceId != nil {
+			details["instanceId"] = *instanceId
+		}
+		event["details"] = details
+
+	case "submission.update.version":
+		if err := addFormRef(ctx, dbPool, event, details); err != nil {
+			return nil, err
+		}
+
+		// The edited submission XML, or the current version if not referenced
+		var instanceId, xml *string
+		err := queryOptionalRow(ctx, dbPool, `
+			SELECT "instanceId"::text, xml FROM submission_defs WHERE id = $1;
+		`, details["submissionDefId"], &instanceId, &xml)
+		if err == nil && xml == nil {
+			err = queryOptionalRow(ctx, dbPool, `
+				SELECT "instanceId"::text, xml FROM submission_defs WHERE "submissionId" = $1 AND current;
+			`, details["submissionId"], &instanceId, &xml)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch submission xml: %w", err)
+		}
+
+		if _, ok := details["instanceId"]; !ok && instanceId != nil {
+			details["instanceId"] = *instanceId
+		}
+		event["details"] = details
+		if xml != nil {
+			event["data"] = map[string]interface{}{"xml": *xml}
+		} else {
+			event["data"] = nil
+		}
+
+	case "submission.delete", "submission.restore", "submission.purge":
+		if err := addFormRef(ctx, dbPool, event, details); err != nil {
+			return nil, err
+		}
+
+		if _, ok := details["instanceId"]; !ok {
+			var instanceId *string
+			err := queryOptionalRow(ctx, dbPool, `
+				SELECT "instanceId"::text FROM submissions WHERE id = $1;
+			`, details["submissionId"], &instanceId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch submission instanceId: %w", err)
+			}
+			if instanceId != nil {
+				details["instanceId"] = *instanceId
+			}
+		}
+		event["details"] = details
+
+	case "submission.attachment.update":
+		if err := addFormRef(ctx, dbPool, event, details); err != nil {
+			return nil, err
+		}
+
+		if _, ok := details["instanceId"]; !ok {
+			var instanceId *string
+			err := queryOptionalRow(ctx, dbPool, `
+				SELECT "instanceId"::text FROM submission_defs WHERE id = $1;
+			`, details["submissionDefId"], &instanceId)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch submission instanceId: %w", err)
+			}
+			if instanceId != nil {
+				details["instanceId"] = *instanceId
+			}
+		}
+		event["details"] = details
+
+	case "form.create", "form.update.publish", "form.update.draft.set", "form.delete":
+		if err := addFormRef(ctx, dbPool, event, details); err != nil {
+			return nil, err
+		}
+
+		// The version of the published or draft form definition, else the
+		// current (or draft, if never published) version
+		var version *string
+		var err error
+		switch action {
+		case "form.update.publish":
+			err = queryOptionalRow(ctx, dbPool, `
+				SELECT version FROM form_defs WHERE id = $1;
+			`, details["newDefId"], &version)
+		case "form.update.draft.set":
+			err = queryOptionalRow(ctx, dbPool, `
+				SELECT version FROM form_defs WHERE id = $1;
+			`, details["newDraftDefId"], &version)
+		}
+		if err == nil && version == nil {
+			version, err = currentFormVersion(ctx, dbPool, event["acteeId"])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch form version: %w", err)
+		}
+		if version != nil {
+			details["version"] = *version
+		}
+		event["details"] = details
+
+	case "dataset.create", "dataset.update":
+		dataset, projectId, err := datasetRef(ctx, dbPool, event["acteeId"])
+		if err != nil {
+			return nil, err
+		}
+		if dataset != "" {
+			details["dataset"] = dataset
+			details["projectId"] = projectId
+		}
+		event["details"] = details
+
+	default:
+		return payload, nil
+	}
+
+	return json.Marshal(event)
+}
+
+// queryOptionalRow scans a single value, leaving dest unchanged if no row
+// matches or the id is missing from the event details
+func queryOptionalRow(ctx context.Context, dbPool *pgxpool.Pool, sql string, id interface{}, dest ...interface{}) error {
+	number, ok := id.(json.Number)
+	if !ok {
+		return nil
+	}
+	intId, err := number.Int64()
+	if err != nil {
+		return fmt.Errorf("invalid id %q: %w", number, err)
+	}
+
+	err = dbPool.QueryRow(ctx, sql, intId).Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+// addFormRef adds the project id and xmlFormId of the form a submission event
+// relates to, from the audit acteeId, if not already in the details
+func addFormRef(ctx context.Context, dbPool *pgxpool.Pool, event, details map[string]interface{}) error {
+	acteeId, ok := event["acteeId"].(string)
+	if !ok || acteeId == "" {
+		return nil
+	}
+	if _, ok := details["xmlFormId"]; ok {
+		return nil
+	}
+
+	var projectId int
+	var xmlFormId string
+	err := dbPool.QueryRow(ctx, `
+		SELECT "projectId", "xmlFormId" FROM forms WHERE "acteeId" = $1;
+	`, acteeId).Scan(&projectId, &xmlFormId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch form: %w", err)
+	}
+
+	details["projectId"] = projectId
+	details["xmlFormId"] = xmlFormId
+	event["details"] = details
+	return nil
+}
+
+// addDatasetRef adds the project id of the dataset an entity event relates
+// to, from the audit acteeId, returning the dataset name
+func addDatasetRef(ctx context.Context, dbPool *pgxpool.Pool, event, details map[string]interface{}) (string, error) {
+	dataset, projectId, err := datasetRef(ctx, dbPool, event["acteeId"])
+	if err != nil {
+		return "", err
+	}
+	if dataset != "" {
+		details["projectId"] = projectId
+	}
+	event["details"] = details
+	return dataset, nil
+}
+
+// currentFormVersion returns the version of the published form with the actee
+// id, or the draft version if never published
+func currentFormVersion(ctx context.Context, dbPool *pgxpool.Pool, acteeId interface{}) (*string, error) {
+	id, ok := acteeId.(string)
+	if !ok || id == "" {
+		return nil, nil
+	}
+
+	var version *string
+	err := dbPool.QueryRow(ctx, `
+		SELECT form_defs.version
+		FROM forms
+		JOIN form_defs ON form_defs.id = COALESCE(forms."currentDefId", forms."draftDefId")
+		WHERE forms."acteeId" = $1;
+	`, id).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return version, err
+}
+
+// datasetRef returns the name and project id of the dataset (entity list)
+// with the actee id, or an empty name if not found
+func datasetRef(ctx context.Context, dbPool *pgxpool.Pool, acteeId interface{}) (string, int, error) {
+	id, ok := acteeId.(string)
+	if !ok || id == "" {
+		return "", 0, nil
+	}
+
+	var name string
+	var projectId int
+	err := dbPool.QueryRow(ctx, `
+		SELECT name, "projectId" FROM datasets WHERE "acteeId" = $1;
+	`, id).Scan(&name, &projectId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch dataset: %w", err)
+	}
+	return name, projectId, nil
+}
+
+// The number of entities created together that are sent with their data.
+// For more, only the uuids are sent, so a large upload is not one payload of
+// many MB. A variable for tests.
+var maxBulkEntityData = 1000
+
+// sourceEntities returns the uuid and data of each entity created from the
+// entity source (e.g. a bulk CSV upload), or only the uuids if there are
+// more than maxBulkEntityData
+func sourceEntities(ctx context.Context, dbPool *pgxpool.Pool, sourceId interface{}) ([]map[string]interface{}, error) {
+	number, ok := sourceId.(json.Number)
+	if !ok {
+		return []map[string]interface{}{}, nil
+	}
+	intId, err := number.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("invalid source id %q: %w", number, err)
+	}
+
+	entities, err := querySourceEntities(ctx, dbPool, intId, true)
+	if err != nil || len(entities) <= maxBulkEntityData {
+		return entities, err
+	}
+	return querySourceEntities(ctx, dbPool, intId, false)
+}
+
+// querySourceEntities returns the entities created from the source, with
+// their data (up to one more than maxBulkEntityData), or only their uuids
+func querySourceEntities(ctx context.Context, dbPool *pgxpool.Pool, sourceId int64, withData bool) ([]map[string]interface{}, error) {
+	limit := any(nil) // LIMIT NULL is no limit
+	columns := "entities.uuid, NULL::jsonb"
+	if withData {
+		limit = maxBulkEntityData + 1
+		columns = "entities.uuid, entity_defs.data"
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT `+columns+`
+		FROM entity_defs
+		JOIN entities ON entities.id = entity_defs."entityId"
+		WHERE entity_defs."sourceId" = $1
+		ORDER BY entities.id
+		LIMIT $2;
+	`, sourceId, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch source entities: %w", err)
+	}
+	defer rows.Close()
+
+	entities := []map[string]interface{}{}
+	for rows.Next() {
+		var uuid string
+		var data []byte
+		if err := rows.Scan(&uuid, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan source entity: %w", err)
+		}
+		entity := map[string]interface{}{"uuid": uuid}
+		if withData {
+			entity["data"] = json.RawMessage(jsonOrNull(data))
+		}
+		entities = append(entities, entity)
+	}
+
+	return entities, rows.Err()
+}
+
+// jsonOrNull returns the JSON, or a JSON null if empty
+func jsonOrNull(data []byte) []byte {
+	if len(data) == 0 {
+		return []byte("null")
+	}
+	return data
+}
@@ -0,0 +1,361 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestEnrichEventUnsupported(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	t.Run("Unsupported Action Unchanged", func(t *testing.T) {
+		// No database access is needed for unsupported actions
+		input := []byte(`{"action":"user.session.create","details":{"id":1}}`)
+		result, err := EnrichEvent(ctx, nil, input)
+		is.NoErr(err)
+		is.Equal(string(result), string(input))
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		_, err := EnrichEvent(ctx, nil, []byte(`invalid`))
+		is.True(err != nil)
+	})
+}
+
+// Note: this test assumes you have a postgres server listening on db:5432
+// with username odk and password odk.
+func TestEnrichEventMissingData(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	conn, err := pool.Acquire(ctx)
+	is.NoErr(err)
+	defer conn.Release()
+
+	createSubmissionDefsTable(ctx, conn, is)
+
+	// The submission does not exist, so data is null
+	input := []byte(`{"action":"submission.create","details":{"submissionDefId":404}}`)
+	result, err := EnrichEvent(ctx, pool, input)
+	is.NoErr(err)
+
+	var event map[string]interface{}
+	err = json.Unmarshal(result, &event)
+	is.NoErr(err)
+	is.Equal(event["data"], nil)
+
+	// Ids are passed through unchanged
+	details, ok := event["details"].(map[string]interface{})
+	is.True(ok)
+	is.Equal(details["submissionDefId"], float64(404))
+
+	// Cleanup
+	conn.Exec(ctx, `DROP TABLE IF EXISTS submission_defs CASCADE;`)
+}
+
+// Note: this test assumes you have a postgres server listening on db:5432
+// with username odk and password odk.
+func TestEnrichEntityEvents(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	conn, err := pool.Acquire(ctx)
+	is.NoErr(err)
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `DROP TABLE IF EXISTS entity_defs, entities, datasets CASCADE;`)
+	is.NoErr(err)
+	createTables := []string{
+		`CREATE TABLE datasets (id int4, name text, "projectId" int4, "acteeId" varchar(36));`,
+		`CREATE TABLE entities (id int4, uuid varchar(255), "datasetId" int4);`,
+		`CREATE TABLE entity_defs (
+			id int4, "entityId" int4, current bool, data jsonb, "sourceId" int4
+		);`,
+		`INSERT INTO datasets VALUES (1, 'trees', 3, 'c5bc4a4b-7d5d-4ef3-a4b5-a0ef2bd1b7b6');`,
+		`INSERT INTO entities VALUES (10, 'abc', 1), (11, 'def', 1);`,
+		`INSERT INTO entity_defs VALUES
+			(20, 10, true, '{"species": "oak"}', 5),
+			(21, 11, true, '{"species": "pine"}', 5);`,
+	}
+	for _, sql := range createTables {
+		_, err := conn.Exec(ctx, sql)
+		is.NoErr(err)
+	}
+
+	enrich := func(input string) map[string]interface{} {
+		result, err := EnrichEvent(ctx, pool, []byte(input))
+		is.NoErr(err)
+		var event map[string]interface{}
+		err = json.Unmarshal(result, &event)
+		is.NoErr(err)
+		return event
+	}
+
+	t.Run("Entity Create", func(t *testing.T) {
+		event := enrich(`{
+			"action":"entity.create",
+			"details":{"entity":{"uuid":"abc","dataset":"trees"},"entityId":10,"entityDefId":20}
+		}`)
+		is.Equal(event["data"], map[string]interface{}{"species": "oak"})
+	})
+
+	t.Run("Entity Resolve Without Version", func(t *testing.T) {
+		// Falls back to the current entity version
+		event := enrich(`{
+			"action":"entity.update.resolve",
+			"details":{"entity":{"uuid":"def","dataset":"trees"},"entityId":11}
+		}`)
+		is.Equal(event["data"], map[string]interface{}{"species": "pine"})
+	})
+
+	t.Run("Entity Delete", func(t *testing.T) {
+		event := enrich(`{
+			"action":"entity.delete",
+			"acteeId":"c5bc4a4b-7d5d-4ef3-a4b5-a0ef2bd1b7b6",
+			"details":{"entity":{"uuid":"abc"}}
+		}`)
+		details := event["details"].(map[string]interface{})
+		entity := details["entity"].(map[string]interface{})
+		is.Equal(entity["uuid"], "abc")
+		is.Equal(entity["dataset"], "trees")
+		is.Equal(details["projectId"], float64(3))
+	})
+
+	t.Run("Entity Bulk Create", func(t *testing.T) {
+		event := enrich(`{
+			"action":"entity.bulk.create",
+			"acteeId":"c5bc4a4b-7d5d-4ef3-a4b5-a0ef2bd1b7b6",
+			"details":{"sourceId":5,"count":2,"source":{"name":"trees.csv","size":100}}
+		}`)
+		details := event["details"].(map[string]interface{})
+		is.Equal(details["dataset"], "trees")
+
+		data := event["data"].(map[string]interface{})
+		is.Equal(data["count"], float64(2))
+		entities := data["entities"].([]interface{})
+		is.Equal(len(entities), 2)
+		is.Equal(entities[0].(map[string]interface{})["uuid"], "abc")
+	})
+
+	t.Run("Entity Bulk Create Over Limit", func(t *testing.T) {
+		// Only the uuids are sent for large uploads
+		maxBulkEntityData = 1
+		defer func() { maxBulkEntityData = 1000 }()
+		event := enrich(`{
+			"action":"entity.bulk.create",
+			"acteeId":"c5bc4a4b-7d5d-4ef3-a4b5-a0ef2bd1b7b6",
+			"details":{"sourceId":5,"count":2,"source":{"name":"trees.csv","size":100}}
+		}`)
+		entities := event["data"].(map[string]interface{})["entities"].([]interface{})
+		is.Equal(entities, []interface{}{
+			map[string]interface{}{"uuid": "abc"},
+			map[string]interface{}{"uuid": "def"},
+		})
+	})
+
+	// Cleanup
+	conn.Exec(ctx, `DROP TABLE IF EXISTS entity_defs, entities, datasets CASCADE;`)
+}
+
+// Note: this test assumes you have a postgres server listening on db:5432
+// with username odk and password odk.
+func TestEnrichSubmissionEvents(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	conn, err := pool.Acquire(ctx)
+	is.NoErr(err)
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `DROP TABLE IF EXISTS forms, submissions, submission_defs CASCADE;`)
+	is.NoErr(err)
+	createTables := []string{
+		`CREATE TABLE forms (id int4, "projectId" int4, "xmlFormId" varchar(255), "acteeId" varchar(36));`,
+		`CREATE TABLE submissions (id int4, "instanceId" varchar(255), "formId" int4);`,
+		`CREATE TABLE submission_defs (
+			id int4, "submissionId" int4, "instanceId" varchar(255), xml text, current bool
+		);`,
+		`INSERT INTO forms VALUES (7, 3, 'buildings', '0e5ae4e3-0ab6-4bd2-9b2e-4b1e1f4d0a52');`,
+		`INSERT INTO submissions VALUES (2, 'uuid:abc', 7);`,
+		`INSERT INTO submission_defs VALUES
+			(1, 2, 'uuid:abc', '<data>original</data>', false),
+			(5, 2, 'uuid:abc', '<data>edited</data>', true);`,
+	}
+	for _, sql := range createTables {
+		_, err := conn.Exec(ctx, sql)
+		is.NoErr(err)
+	}
+
+	enrich := func(input string) map[string]interface{} {
+		result, err := EnrichEvent(ctx, pool, []byte(input))
+		is.NoErr(err)
+		var event map[string]interface{}
+		err = json.Unmarshal(result, &event)
+		is.NoErr(err)
+		return event
+	}
+
+	t.Run("Submission Update Version", func(t *testing.T) {
+		event := enrich(`{
+			"action":"submission.update.version",
+			"acteeId":"0e5ae4e3-0ab6-4bd2-9b2e-4b1e1f4d0a52",
+			"details":{"submissionId":2,"submissionDefId":5}
+		}`)
+		details := event["details"].(map[string]interface{})
+		is.Equal(details["instanceId"], "uuid:abc")
+		is.Equal(details["projectId"], float64(3))
+		is.Equal(details["xmlFormId"], "buildings")
+
+		data := event["data"].(map[string]interface{})
+		is.Equal(data["xml"], "<data>edited</data>")
+	})
+
+	t.Run("Submission Delete", func(t *testing.T) {
+		event := enrich(`{
+			"action":"submission.delete",
+			"acteeId":"0e5ae4e3-0ab6-4bd2-9b2e-4b1e1f4d0a52",
+			"details":{"submissionId":2}
+		}`)
+		details := event["details"].(map[string]interface{})
+		is.Equal(details["instanceId"], "uuid:abc")
+		is.Equal(details["xmlFormId"], "buildings")
+	})
+
+	t.Run("Submission Attachment Update", func(t *testing.T) {
+		event := enrich(`{
+			"action":"submission.attachment.update",
+			"acteeId":"0e5ae4e3-0ab6-4bd2-9b2e-4b1e1f4d0a52",
+			"details":{"submissionDefId":1,"name":"photo.jpg"}
+		}`)
+		details := event["details"].(map[string]interface{})
+		is.Equal(details["instanceId"], "uuid:abc")
+		is.Equal(details["name"], "photo.jpg")
+		is.Equal(details["projectId"], float64(3))
+	})
+
+	// Cleanup
+	conn.Exec(ctx, `DROP TABLE IF EXISTS forms, submissions, submission_defs CASCADE;`)
+}
+
+// Note: this test assumes you have a postgres server listening on db:5432
+// with username odk and password odk.
+func TestEnrichFormEvents(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	conn, err := pool.Acquire(ctx)
+	is.NoErr(err)
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `DROP TABLE IF EXISTS forms, form_defs, datasets CASCADE;`)
+	is.NoErr(err)
+	createTables := []string{
+		`CREATE TABLE forms (
+			id int4, "projectId" int4, "xmlFormId" varchar(255), "acteeId" varchar(36),
+			"currentDefId" int4, "draftDefId" int4
+		);`,
+		`CREATE TABLE form_defs (id int4, "formId" int4, version text);`,
+		`CREATE TABLE datasets (id int4, name text, "projectId" int4, "acteeId" varchar(36));`,
+		`INSERT INTO forms VALUES (7, 3, 'buildings', '0e5ae4e3-0ab6-4bd2-9b2e-4b1e1f4d0a52', 2, 4);`,
+		`INSERT INTO form_defs VALUES (1, 7, 'v1'), (2, 7, 'v2'), (4, 7, 'v3-draft');`,
+		`INSERT INTO datasets VALUES (1, 'trees', 3, 'c5bc4a4b-7d5d-4ef3-a4b5-a0ef2bd1b7b6');`,
+	}
+	for _, sql := range createTables {
+		_, err := conn.Exec(ctx, sql)
+		is.NoErr(err)
+	}
+
+	enrich := func(input string) map[string]interface{} {
+		result, err := EnrichEvent(ctx, pool, []byte(input))
+		is.NoErr(err)
+		var event map[string]interface{}
+		err = json.Unmarshal(result, &event)
+		is.NoErr(err)
+		return event["details"].(map[string]interface{})
+	}
+
+	t.Run("Form Publish", func(t *testing.T) {
+		details := enrich(`{
+			"action":"form.update.publish",
+			"acteeId":"0e5ae4e3-0ab6-4bd2-9b2e-4b1e1f4d0a52",
+			"details":{"oldDefId":1,"newDefId":2}
+		}`)
+		is.Equal(details["projectId"], float64(3))
+		is.Equal(details["xmlFormId"], "buildings")
+		is.Equal(details["version"], "v2")
+	})
+
+	t.Run("Form Draft Set", func(t *testing.T) {
+		details := enrich(`{
+			"action":"form.update.draft.set",
+			"acteeId":"0e5ae4e3-0ab6-4bd2-9b2e-4b1e1f4d0a52",
+			"details":{"newDraftDefId":4}
+		}`)
+		is.Equal(details["version"], "v3-draft")
+	})
+
+	t.Run("Form Delete", func(t *testing.T) {
+		// Uses the current published version
+		details := enrich(`{
+			"action":"form.delete",
+			"acteeId":"0e5ae4e3-0ab6-4bd2-9b2e-4b1e1f4d0a52",
+			"details":{}
+		}`)
+		is.Equal(details["version"], "v2")
+	})
+
+	t.Run("Dataset Create", func(t *testing.T) {
+		details := enrich(`{
+			"action":"dataset.create",
+			"acteeId":"c5bc4a4b-7d5d-4ef3-a4b5-a0ef2bd1b7b6",
+			"details":{"fields":[["species","/species"]]}
+		}`)
+		is.Equal(details["dataset"], "trees")
+		is.Equal(details["projectId"], float64(3))
+	})
+
+	// Cleanup
+	conn.Exec(ctx, `DROP TABLE IF EXISTS forms, form_defs, datasets CASCADE;`)
+}
@@ -11,7 +11,14 @@ import (
 	"log/slog"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hotosm/central-webhook/metrics"
+	"github.com/hotosm/central-webhook/tracing"
 )
 
 // Notifier interface wraps a Listener. It holds a single Postgres connection
@@ -23,15 +30,58 @@ type Notifier interface {
 	// the caller to receive data published to that channel
 	Listen(channel string) Subscription
 
-	// this runs the receiving loop forever
+	// this runs the receiving loop until the context is done, reconnecting
+	// if the connection to the database is lost
 	Run(ctx context.Context) error
+
+	// Returns the number of notifications dropped because a subscription
+	// buffer was full
+	Dropped() uint64
+
+	// Returns the time of the last successful ping of the database
+	// connection, or zero if not connected, e.g. while reconnecting
+	LastPing() time.Time
+}
+
+// OverflowPolicy decides what happens to a notification when a subscription
+// buffer is full
+type OverflowPolicy string
+
+const (
+	// Wait until there is space, without receiving more notifications.
+	// Postgres queues the notifications meanwhile, so none are lost.
+	OverflowBlock OverflowPolicy = "block"
+	// Drop the notification that does not fit
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// Drop the oldest notification in the buffer, to make space
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// Write notifications to a file until there is space
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// OverflowPolicies are the valid overflow policies
+var OverflowPolicies = []OverflowPolicy{OverflowBlock, OverflowDropNewest, OverflowDropOldest, OverflowSpill}
+
+// The notifier defaults, for options that are not set
+const (
+	defaultBufferSize = 100
+	defaultOverflow   = OverflowBlock
+)
+
+// NotifierOptions configures the subscriptions of a Notifier. Zero values use
+// the defaults.
+type NotifierOptions struct {
+	BufferSize int            // The notifications buffered per subscription
+	Overflow   OverflowPolicy // What to do when the buffer is full
+	SpillDir   string         // The directory for OverflowSpill files, the temp dir if empty
 }
 
 // Subscription provides a means to listen on a particular topic. Notifiers
 // return Subscriptions that callers can use to receive updates.
 type Subscription interface {
-	NotificationC() <-chan []byte
+	NotificationC() <-chan Message
 	EstablishedC() <-chan struct{}
+	ReconnectedC() <-chan struct{}
 	Unlisten(ctx context.Context)
 }
 
@@ -41,18 +91,28 @@ type Notification struct {
 	Payload []byte `json:"payload"`
 }
 
+// Message is a notification payload received by a subscription, with the
+// span of receiving it, to continue the trace while processing it
+type Message struct {
+	Payload     []byte
+	SpanContext trace.SpanContext
+}
+
 type subscription struct {
 	channel    string
-	listenChan chan []byte
+	listenChan chan Message
 	notifier   *notifier
+	spill      *spillFile    // Only for OverflowSpill
+	done       chan struct{} // Closed on Unlisten, to stop blocked sends
 
+	reconnectedChan      chan struct{}
 	establishedChan      chan struct{}
 	establishedChanClose func()
 	unlistenOnce         sync.Once
 }
 
 // NotificationC returns the underlying notification channel.
-func (s *subscription) NotificationC() <-chan []byte { return s.listenChan }
+func (s *subscription) NotificationC() <-chan Message { return s.listenChan }
 
 // EstablishedC is a channel that's closed after the Notifier has successfully
 // established a connection to the database and started listening for updates.
@@ -62,9 +122,24 @@ func (s *subscription) NotificationC() <-chan []byte { return s.listenChan }
 // context done, a stop channel, and/or a timeout.
 func (s *subscription) EstablishedC() <-chan struct{} { return s.establishedChan }
 
+// ReconnectedC receives a value each time the Notifier reconnects to the
+// database after losing the connection. Notifications sent while it was
+// disconnected are lost, so callers should catch up from another source.
+//
+// Values are not queued: several reconnects before the caller receives are
+// signalled once.
+func (s *subscription) ReconnectedC() <-chan struct{} { return s.reconnectedChan }
+
 // Unlisten unregisters the subscriber from its notifier
 func (s *subscription) Unlisten(ctx context.Context) {
 	s.unlistenOnce.Do(func() {
+		close(s.done)
+		if s.spill != nil {
+			if err := s.spill.close(); err != nil {
+				s.notifier.log.Error("error removing spill file", "err", err, "channel", s.channel)
+			}
+		}
+
 		// Unlisten uses background context in case of cancellation.
 		if err := s.notifier.unlisten(context.Background(), s); err != nil {
 			s.notifier.log.Error("error unlistening on channel", "err", err, "channel", s.channel)
@@ -76,20 +151,59 @@ type notifier struct {
 	mu                        sync.RWMutex
 	log                       *slog.Logger
 	listener                  Listener
+	opts                      NotifierOptions
 	subscriptions             map[string][]*subscription
 	channelChanges            []channelChange
 	waitForNotificationCancel context.CancelFunc
+	dropped                   atomic.Uint64
+	lastPing                  atomic.Int64 // Unix nanoseconds, 0 if not connected
+	pingInterval              time.Duration
+	minReconnectDelay         time.Duration
+	maxReconnectDelay         time.Duration
 }
 
+// NewNotifier returns a Notifier with the default options
 func NewNotifier(log *slog.Logger, listener Listener) Notifier {
+	return NewNotifierWithOptions(log, listener, NotifierOptions{})
+}
+
+// NewNotifierWithOptions returns a Notifier with the subscription buffer
+// size and overflow policy
+func NewNotifierWithOptions(log *slog.Logger, listener Listener, opts NotifierOptions) Notifier {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = defaultOverflow
+	}
+
 	return &notifier{
 		mu:                        sync.RWMutex{},
 		log:                       log,
 		listener:                  listener,
+		opts:                      opts,
 		subscriptions:             make(map[string][]*subscription),
 		channelChanges:            []channelChange{},
 		waitForNotificationCancel: context.CancelFunc(func() {}),
+		pingInterval:              pingInterval,
+		minReconnectDelay:         minReconnectDelay,
+		maxReconnectDelay:         maxReconnectDelay,
+	}
+}
+
+// Dropped returns the number of notifications dropped because a
+// subscription buffer was full
+func (n *notifier) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+// LastPing returns the time of the last successful ping of the database
+// connection, or zero if not connected
+func (n *notifier) LastPing() time.Time {
+	if nanos := n.lastPing.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
 	}
+	return time.Time{}
 }
 
 type channelChange struct {
@@ -106,12 +220,23 @@ func (n *notifier) Listen(channel string) Subscription {
 	existingSubs := n.subscriptions[channel]
 
 	sub := &subscription{
-		channel:    channel,
-		listenChan: make(chan []byte, 2),
-		notifier:   n,
+		channel:         channel,
+		listenChan:      make(chan Message, n.opts.BufferSize),
+		notifier:        n,
+		done:            make(chan struct{}),
+		reconnectedChan: make(chan struct{}, 1),
 	}
 	n.subscriptions[channel] = append(existingSubs, sub)
 
+	if n.opts.Overflow == OverflowSpill {
+		sub.spill = newSpillFile(n.opts.SpillDir)
+		go func() {
+			if err := sub.spill.forward(sub.listenChan, sub.done); err != nil {
+				n.log.Error("error forwarding spilled notifications", "err", err, "channel", channel)
+			}
+		}()
+	}
+
 	if len(existingSubs) > 0 {
 		// If there's already another subscription for this channel, reuse its
 		// established channel. It may already be closed (to indicate that the
@@ -140,6 +265,16 @@ func (n *notifier) Listen(channel string) Subscription {
 
 const listenerTimeout = 10 * time.Second
 
+// How often the connection is pinged, to detect a connection that was
+// silently dropped, as waiting for notifications would not return an error
+const pingInterval = 30 * time.Second
+
+// The delays between attempts to reconnect, doubling after each failure
+const (
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
 // Listens on a topic with an appropriate logging statement. Should be preferred
 // to `listener.Listen` for improved logging/telemetry.
 func (n *notifier) listenerListen(ctx context.Context, channel string) error {
@@ -174,11 +309,16 @@ func (n *notifier) processChannelChanges(ctx context.Context) error {
 	n.log.Debug("processing channel changes...")
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	for _, u := range n.channelChanges {
+	for i, u := range n.channelChanges {
 		switch u.operation {
 		case "listen":
 			n.log.Debug("listening to new channel", "channel", u.channel)
-			n.listenerListen(ctx, u.channel)
+			if err := n.listenerListen(ctx, u.channel); err != nil {
+				// Keep the failed and remaining changes, the channels are
+				// listened to again on reconnect
+				n.channelChanges = n.channelChanges[i:]
+				return err
+			}
 			u.close()
 		case "unlisten":
 			n.log.Debug("unlistening from channel", "channel", u.channel)
@@ -187,6 +327,7 @@ func (n *notifier) processChannelChanges(ctx context.Context) error {
 			n.log.Error("got unexpected change operation", "operation", u.operation)
 		}
 	}
+	n.channelChanges = n.channelChanges[:0]
 	return nil
 }
 
@@ -196,6 +337,12 @@ func (n *notifier) processChannelChanges(ctx context.Context) error {
 // signal good/expected exit conditions, meaning a caller can simply call
 // handleNextNotification again.
 func (n *notifier) waitOnce(ctx context.Context) error {
+	if time.Since(n.LastPing()) >= n.pingInterval {
+		if err := n.ping(ctx); err != nil {
+			return err
+		}
+	}
+
 	if err := n.processChannelChanges(ctx); err != nil {
 		return err
 	}
@@ -234,18 +381,110 @@ func (n *notifier) waitOnce(ctx context.Context) error {
 		return err
 	}
 
+	metrics.NotificationsReceived.WithLabelValues(notification.Channel).Inc()
+
+	// The span ends once the notification is buffered, and is the parent of
+	// the spans processing it
+	_, span := tracing.Tracer().Start(ctx, notification.Channel+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "postgresql"),
+			attribute.String("messaging.destination.name", notification.Channel),
+			attribute.Int("messaging.message.body.size", len(notification.Payload)),
+		),
+	)
+	defer span.End()
+	msg := Message{Payload: []byte(notification.Payload), SpanContext: span.SpanContext()}
+
+	// The lock is not held while sending, as it may block, so subscriptions
+	// can still be changed
 	n.mu.RLock()
-	defer n.mu.RUnlock()
+	subs := slices.Clone(n.subscriptions[notification.Channel])
+	n.mu.RUnlock()
+
+	for _, sub := range subs {
+		n.send(ctx, sub, msg)
+	}
+
+	return nil
+}
+
+// send sends the notification to the subscription, following the overflow
+// policy if the subscription buffer is full
+func (n *notifier) send(ctx context.Context, sub *subscription, msg Message) {
+	select {
+	case <-sub.done:
+		return
+	default:
+	}
+
+	switch n.opts.Overflow {
+	case OverflowBlock:
+		select {
+		case sub.listenChan <- msg:
+			return
+		default:
+		}
+		n.log.Warn("subscription buffer full, waiting", "channel", sub.channel)
+		// Keep pinging while waiting, so the connection is kept alive and
+		// reported as connected
+		ticker := time.NewTicker(n.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case sub.listenChan <- msg:
+				return
+			case <-sub.done:
+				return
+			case <-ctx.Done():
+				n.drop(sub, msg)
+				return
+			case <-ticker.C:
+				if err := n.ping(ctx); err != nil {
+					n.log.Error("error pinging database while waiting", "err", err, "channel", sub.channel)
+				}
+			}
+		}
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case sub.listenChan <- msg:
+				return
+			default:
+			}
+			select {
+			case oldest := <-sub.listenChan:
+				n.drop(sub, oldest)
+			default:
+			}
+		}
+
+	case OverflowSpill:
+		if err := sub.spill.send(sub.listenChan, msg); err != nil {
+			n.log.Error("error spilling notification", "err", err, "channel", sub.channel)
+			n.drop(sub, msg)
+		}
 
-	for _, sub := range n.subscriptions[notification.Channel] {
+	default: // OverflowDropNewest
 		select {
-		case sub.listenChan <- []byte(notification.Payload):
+		case sub.listenChan <- msg:
 		default:
-			n.log.Error("dropped notification due to full buffer", "payload", notification.Payload)
+			n.drop(sub, msg)
 		}
 	}
+}
 
-	return nil
+// drop counts and logs a notification that was not delivered to the subscription
+func (n *notifier) drop(sub *subscription, msg Message) {
+	n.dropped.Add(1)
+	metrics.NotificationsDropped.WithLabelValues(sub.channel).Inc()
+	n.log.Error(
+		"dropped notification due to full buffer",
+		"channel", sub.channel,
+		"payload", string(msg.Payload),
+		"totalDropped", n.dropped.Load(),
+	)
 }
 
 func (n *notifier) unlisten(ctx context.Context, sub *subscription) error {
@@ -272,11 +511,99 @@ func (n *notifier) unlisten(ctx context.Context, sub *subscription) error {
 	return nil
 }
 
+// ping checks the connection to the database
+func (n *notifier) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, listenerTimeout)
+	defer cancel()
+
+	if err := n.listener.Ping(ctx); err != nil {
+		return fmt.Errorf("error pinging database: %w", err)
+	}
+	n.lastPing.Store(time.Now().UnixNano())
+	return nil
+}
+
 func (n *notifier) Run(ctx context.Context) error {
 	for {
 		err := n.waitOnce(ctx)
-		if err != nil || ctx.Err() != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			n.log.Error("lost connection to database, reconnecting", "err", err)
+			n.lastPing.Store(0)
+			if err := n.reconnect(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reconnect replaces the listener connection, retrying with backoff until it
+// succeeds or the context is done, then listens to the channels of all
+// subscriptions again and signals them on ReconnectedC
+func (n *notifier) reconnect(ctx context.Context) error {
+	delay := n.minReconnectDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		err := n.reconnectOnce(ctx)
+		if err == nil {
+			n.log.Info("reconnected to database", "attempts", attempt)
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		n.log.Error("failed to reconnect to database", "err", err, "attempt", attempt)
+		delay = min(delay*2, n.maxReconnectDelay)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, subs := range n.subscriptions {
+		for _, sub := range subs {
+			select {
+			case sub.reconnectedChan <- struct{}{}:
+			default: // already signalled
+			}
+		}
+	}
+	return nil
+}
+
+// reconnectOnce closes the listener connection, connects again, and listens
+// to the channels of all subscriptions
+func (n *notifier) reconnectOnce(ctx context.Context) error {
+	if err := n.listener.Close(ctx); err != nil {
+		n.log.Debug("error closing lost connection", "err", err)
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, listenerTimeout)
+	defer cancel()
+	if err := n.listener.Connect(connectCtx); err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for channel := range n.subscriptions {
+		if err := n.listenerListen(ctx, channel); err != nil {
 			return err
 		}
 	}
+
+	// Pending listens are done, as all channels with subscriptions are
+	// listened to
+	for _, u := range n.channelChanges {
+		if u.operation == "listen" {
+			u.close()
+		}
+	}
+	n.channelChanges = n.channelChanges[:0]
+	return nil
 }
@@ -2,12 +2,20 @@ package db
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/matryer/is"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // Note: these tests assume you have a postgres server listening on db:5432
@@ -64,7 +72,7 @@ func TestNotifier(t *testing.T) {
 		<-sub.EstablishedC()
 		for i := 0; i < 5; i++ {
 			msg := <-sub.NotificationC()
-			out <- string(msg)
+			out <- string(msg.Payload)
 		}
 		close(out)
 		wg.Done()
@@ -81,3 +89,287 @@ func TestNotifier(t *testing.T) {
 	listener.Close(ctx)
 	wg.Wait()
 }
+
+// fakeListener returns notifications sent to it, without a database. An
+// error sent to errs is returned by WaitForNotification, as if the
+// connection was lost.
+type fakeListener struct {
+	notifications chan *Notification
+	errs          chan error
+	failConnects  atomic.Int32 // The number of calls to Connect to fail
+	connects      atomic.Int32
+	listens       atomic.Int32
+	pings         atomic.Int32
+}
+
+func (l *fakeListener) Close(ctx context.Context) error { return nil }
+func (l *fakeListener) Connect(ctx context.Context) error {
+	l.connects.Add(1)
+	if l.failConnects.Add(-1) >= 0 {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+func (l *fakeListener) Listen(ctx context.Context, topic string) error {
+	l.listens.Add(1)
+	return nil
+}
+func (l *fakeListener) Ping(ctx context.Context) error                   { l.pings.Add(1); return nil }
+func (l *fakeListener) Unlisten(ctx context.Context, topic string) error { return nil }
+func (l *fakeListener) WaitForNotification(ctx context.Context) (*Notification, error) {
+	select {
+	case notification := <-l.notifications:
+		return notification, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// notifyAll sends the payloads to a notifier with the options, before
+// reading any, returning what the subscription received and the notifier
+func notifyAll(t *testing.T, opts NotifierOptions, payloads ...string) ([]string, Notifier) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener := &fakeListener{notifications: make(chan *Notification)}
+	n := NewNotifierWithOptions(log, listener, opts)
+	go n.Run(ctx)
+	sub := n.Listen("foo")
+	defer sub.Unlisten(ctx)
+	<-sub.EstablishedC()
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for _, payload := range payloads {
+			select {
+			case listener.notifications <- &Notification{Channel: "foo", Payload: []byte(payload)}:
+			case <-time.After(50 * time.Millisecond):
+				return // the notifier is blocked
+			}
+		}
+	}()
+	<-sent
+	time.Sleep(20 * time.Millisecond) // for the notifier to handle the last notification
+
+	var received []string
+	for {
+		select {
+		case msg := <-sub.NotificationC():
+			received = append(received, string(msg.Payload))
+			if len(received) == len(payloads) {
+				is.Equal(n.Dropped(), uint64(0))
+				return received, n
+			}
+		case <-time.After(200 * time.Millisecond):
+			return received, n
+		}
+	}
+}
+
+func TestNotifierOverflow(t *testing.T) {
+	payloads := []string{"1", "2", "3", "4", "5"}
+
+	t.Run("Drop Newest", func(t *testing.T) {
+		is := is.New(t)
+		received, n := notifyAll(t, NotifierOptions{BufferSize: 2, Overflow: OverflowDropNewest}, payloads...)
+		is.Equal(received, []string{"1", "2"})
+		is.Equal(n.Dropped(), uint64(3))
+	})
+
+	t.Run("Drop Oldest", func(t *testing.T) {
+		is := is.New(t)
+		received, n := notifyAll(t, NotifierOptions{BufferSize: 2, Overflow: OverflowDropOldest}, payloads...)
+		is.Equal(received, []string{"4", "5"})
+		is.Equal(n.Dropped(), uint64(3))
+	})
+
+	t.Run("Spill", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		received, n := notifyAll(t, NotifierOptions{BufferSize: 2, Overflow: OverflowSpill, SpillDir: dir}, payloads...)
+		is.Equal(received, payloads)
+		is.Equal(n.Dropped(), uint64(0))
+
+		// The spill file is removed on unlisten
+		files, err := os.ReadDir(dir)
+		is.NoErr(err)
+		is.Equal(len(files), 0)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		is := is.New(t)
+		// The notifier stops receiving until there is space, so all are
+		// received once the subscriber reads them
+		listener := &fakeListener{notifications: make(chan *Notification)}
+		log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		n := NewNotifierWithOptions(log, listener, NotifierOptions{BufferSize: 2, Overflow: OverflowBlock})
+		go n.Run(ctx)
+		sub := n.Listen("foo")
+		<-sub.EstablishedC()
+
+		go func() {
+			for _, payload := range payloads {
+				listener.notifications <- &Notification{Channel: "foo", Payload: []byte(payload)}
+			}
+		}()
+		time.Sleep(50 * time.Millisecond)
+		is.Equal(len(sub.NotificationC()), 2) // full, with the rest waiting
+
+		var received []string
+		for range payloads {
+			received = append(received, string((<-sub.NotificationC()).Payload))
+		}
+		is.Equal(received, payloads)
+		is.Equal(n.Dropped(), uint64(0))
+
+		// Unlisten does not wait for a blocked notification
+		go func() { listener.notifications <- &Notification{Channel: "foo", Payload: []byte("6")} }()
+		go func() { listener.notifications <- &Notification{Channel: "foo", Payload: []byte("7")} }()
+		go func() { listener.notifications <- &Notification{Channel: "foo", Payload: []byte("8")} }()
+		time.Sleep(50 * time.Millisecond)
+		sub.Unlisten(ctx)
+	})
+
+	t.Run("Block Pings", func(t *testing.T) {
+		is := is.New(t)
+		// The connection is still pinged while waiting for space
+		listener := &fakeListener{notifications: make(chan *Notification)}
+		log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		n := NewNotifierWithOptions(log, listener, NotifierOptions{BufferSize: 1, Overflow: OverflowBlock}).(*notifier)
+		n.pingInterval = 10 * time.Millisecond
+		go n.Run(ctx)
+		sub := n.Listen("foo")
+		defer sub.Unlisten(ctx)
+		<-sub.EstablishedC()
+
+		listener.notifications <- &Notification{Channel: "foo", Payload: []byte("1")}
+		listener.notifications <- &Notification{Channel: "foo", Payload: []byte("2")}
+		time.Sleep(20 * time.Millisecond)
+		pings := listener.pings.Load()
+		time.Sleep(50 * time.Millisecond)
+		is.True(listener.pings.Load() > pings)
+		is.True(time.Since(n.LastPing()) < 30*time.Millisecond)
+
+		// Subscriptions can still be changed
+		other := n.Listen("bar")
+		defer other.Unlisten(ctx)
+		is.Equal(string((<-sub.NotificationC()).Payload), "1")
+		is.Equal(string((<-sub.NotificationC()).Payload), "2")
+	})
+}
+
+func TestNotifierReconnect(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener := &fakeListener{notifications: make(chan *Notification), errs: make(chan error)}
+	n := NewNotifier(log, listener).(*notifier)
+	n.minReconnectDelay = time.Millisecond
+	n.maxReconnectDelay = 5 * time.Millisecond
+
+	runErr := make(chan error)
+	go func() { runErr <- n.Run(ctx) }()
+	sub := n.Listen("foo")
+	other := n.Listen("bar")
+	<-sub.EstablishedC()
+	<-other.EstablishedC()
+	is.Equal(listener.listens.Load(), int32(2))
+
+	// The first two attempts to connect again fail
+	listener.failConnects.Store(2)
+	listener.errs <- errors.New("conn closed")
+
+	select {
+	case <-sub.ReconnectedC():
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not signalled after reconnecting")
+	}
+	<-other.ReconnectedC()
+	is.Equal(listener.connects.Load(), int32(3))
+	is.Equal(listener.listens.Load(), int32(4)) // both channels listened to again
+
+	// Notifications are received after reconnecting
+	listener.notifications <- &Notification{Channel: "foo", Payload: []byte("1")}
+	is.Equal(string((<-sub.NotificationC()).Payload), "1")
+
+	cancel()
+	is.True(errors.Is(<-runErr, context.Canceled))
+}
+
+func TestNotifierLastPing(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener := &fakeListener{notifications: make(chan *Notification), errs: make(chan error)}
+	n := NewNotifier(log, listener).(*notifier)
+	n.minReconnectDelay = time.Millisecond
+	n.maxReconnectDelay = time.Millisecond
+	is.True(n.LastPing().IsZero())
+
+	go n.Run(ctx)
+	sub := n.Listen("foo")
+	<-sub.EstablishedC()
+	is.True(!n.LastPing().IsZero())
+
+	// Not connected while reconnecting
+	listener.failConnects.Store(1000)
+	listener.errs <- errors.New("conn closed")
+	time.Sleep(20 * time.Millisecond)
+	is.True(n.LastPing().IsZero())
+
+	// Pinged again once reconnected
+	listener.failConnects.Store(0)
+	<-sub.ReconnectedC()
+	deadline := time.Now().Add(time.Second)
+	for n.LastPing().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	is.True(!n.LastPing().IsZero())
+}
+
+func TestNotifierTrace(t *testing.T) {
+	is := is.New(t)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	// The span context is kept for spilled notifications too
+	opts := NotifierOptions{BufferSize: 1, Overflow: OverflowSpill, SpillDir: t.TempDir()}
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener := &fakeListener{notifications: make(chan *Notification)}
+	n := NewNotifierWithOptions(log, listener, opts)
+	go n.Run(ctx)
+	sub := n.Listen("foo")
+	defer sub.Unlisten(ctx)
+	<-sub.EstablishedC()
+
+	for _, payload := range []string{"1", "2", "3"} {
+		listener.notifications <- &Notification{Channel: "foo", Payload: []byte(payload)}
+	}
+	var received []Message
+	for range 3 {
+		received = append(received, <-sub.NotificationC())
+	}
+
+	spans := recorder.Ended()
+	is.Equal(len(spans), 3)
+	for i, msg := range received {
+		is.Equal(spans[i].Name(), "foo receive")
+		is.Equal(msg.SpanContext, spans[i].SpanContext())
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The outbox table holds a copy of every event sent via pg_notify, so events
+// created while the webhook service is down (or reconnecting) can be
+// delivered once it is running again.
+const createOutboxSQL = `
+	CREATE TABLE IF NOT EXISTS webhook_outbox (
+		id bigserial PRIMARY KEY,
+		action text NOT NULL,
+		payload jsonb NOT NULL,
+		"createdAt" timestamptz NOT NULL DEFAULT now(),
+		"deliveredAt" timestamptz
+	);
+
+	CREATE INDEX IF NOT EXISTS webhook_outbox_pending_idx
+		ON webhook_outbox (id)
+		WHERE "deliveredAt" IS NULL;
+`
+
+// OutboxEvent is an event stored in the outbox table, pending delivery
+type OutboxEvent struct {
+	ID      int64
+	Action  string
+	Payload []byte
+}
+
+// CreateOutbox creates the outbox table, if it does not already exist
+func CreateOutbox(ctx context.Context, dbPool *pgxpool.Pool) error {
+	if _, err := dbPool.Exec(ctx, createOutboxSQL); err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	return nil
+}
+
+// PendingOutboxEvents returns up to `limit` undelivered events, oldest first
+func PendingOutboxEvents(ctx context.Context, dbPool *pgxpool.Pool, limit int) ([]OutboxEvent, error) {
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, action, payload::text
+		FROM webhook_outbox
+		WHERE "deliveredAt" IS NULL
+		ORDER BY id
+		LIMIT $1;
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var events []OutboxEvent
+	for rows.Next() {
+		var event OutboxEvent
+		var payload string
+		if err := rows.Scan(&event.ID, &event.Action, &payload); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox row: %w", err)
+		}
+		event.Payload = []byte(payload)
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// GetOutboxEvent returns a single outbox event by id
+func GetOutboxEvent(ctx context.Context, dbPool *pgxpool.Pool, id int64) (*OutboxEvent, error) {
+	event := OutboxEvent{ID: id}
+	var payload string
+	err := dbPool.QueryRow(ctx, `
+		SELECT action, payload::text FROM webhook_outbox WHERE id = $1;
+	`, id).Scan(&event.Action, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbox event %d: %w", id, err)
+	}
+	event.Payload = []byte(payload)
+	return &event, nil
+}
+
+// IsOutboxEventPending reports whether the outbox event has not been delivered yet
+func IsOutboxEventPending(ctx context.Context, dbPool *pgxpool.Pool, id int64) (bool, error) {
+	var pending bool
+	err := dbPool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM webhook_outbox WHERE id = $1 AND "deliveredAt" IS NULL
+		);
+	`, id).Scan(&pending)
+	if err != nil {
+		return false, fmt.Errorf("failed to check outbox event %d: %w", id, err)
+	}
+	return pending, nil
+}
+
+// MarkOutboxDelivered marks an outbox event as delivered, so it is not sent again
+func MarkOutboxDelivered(ctx context.Context, dbPool *pgxpool.Pool, id int64) error {
+	_, err := dbPool.Exec(ctx, `
+		UPDATE webhook_outbox SET "deliveredAt" = now() WHERE id = $1;
+	`, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event %d delivered: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// Note: these tests assume you have a postgres server listening on db:5432
+// with username odk and password odk.
+//
+// The easiest way to ensure this is to run the tests with docker compose:
+// docker compose run --rm webhook
+
+func TestOutbox(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	// Get connection and defer close
+	conn, err := pool.Acquire(ctx)
+	is.NoErr(err)
+	defer conn.Release()
+
+	// Start with an empty outbox
+	_, err = conn.Exec(ctx, `DROP TABLE IF EXISTS webhook_outbox CASCADE;`)
+	is.NoErr(err)
+
+	// Create submission_defs and audits_test tables
+	createSubmissionDefsTable(ctx, conn, is)
+	createAuditTestsTable(ctx, conn, is)
+
+	_, err = conn.Exec(ctx, `
+		INSERT INTO submission_defs (id, "submissionId", xml)
+		VALUES (1, 2, '<data id="xxx">');
+	`)
+	is.NoErr(err)
+
+	// Create audit trigger, which also creates the outbox table
+	err = CreateTrigger(ctx, pool, "audits_test")
+	is.NoErr(err)
+
+	// Insert audit records, with nothing listening for notifications
+	_, err = conn.Exec(ctx, `
+		INSERT INTO audits_test ("actorId", action, details)
+		VALUES
+			(5, 'submission.create', '{"submissionDefId": 1}'),
+			(5, 'invalid.event', '{"submissionDefId": 1}'),
+			(5, 'submission.create', '{"submissionDefId": 1}');
+	`)
+	is.NoErr(err)
+
+	// Only supported events are stored in the outbox, oldest first
+	events, err := PendingOutboxEvents(ctx, pool, 10)
+	is.NoErr(err)
+	is.Equal(len(events), 2)
+	is.True(events[0].ID < events[1].ID)
+	is.Equal(events[0].Action, "submission.create")
+
+	// The outbox stores the audit event, to be enriched on delivery
+	var payload map[string]interface{}
+	err = json.Unmarshal(events[0].Payload, &payload)
+	is.NoErr(err)
+	is.Equal(payload["action"], "submission.create")
+	details, ok := payload["details"].(map[string]interface{})
+	is.True(ok)
+	is.Equal(details["submissionDefId"], float64(1))
+
+	// Events can be fetched by id
+	event, err := GetOutboxEvent(ctx, pool, events[1].ID)
+	is.NoErr(err)
+	is.Equal(event.Action, "submission.create")
+
+	// The limit is respected
+	limited, err := PendingOutboxEvents(ctx, pool, 1)
+	is.NoErr(err)
+	is.Equal(len(limited), 1)
+
+	// Delivered events are no longer pending
+	pending, err := IsOutboxEventPending(ctx, pool, events[0].ID)
+	is.NoErr(err)
+	is.True(pending)
+
+	err = MarkOutboxDelivered(ctx, pool, events[0].ID)
+	is.NoErr(err)
+
+	pending, err = IsOutboxEventPending(ctx, pool, events[0].ID)
+	is.NoErr(err)
+	is.True(!pending)
+
+	events, err = PendingOutboxEvents(ctx, pool, 10)
+	is.NoErr(err)
+	is.Equal(len(events), 1)
+
+	// Updates to audit records do not add them to the outbox again
+	_, err = conn.Exec(ctx, `UPDATE audits_test SET "actorId" = 6;`)
+	is.NoErr(err)
+	events, err = PendingOutboxEvents(ctx, pool, 10)
+	is.NoErr(err)
+	is.Equal(len(events), 1)
+
+	// Cleanup
+	conn.Exec(ctx, `DROP TABLE IF EXISTS submission_defs, audits_test, webhook_outbox CASCADE;`)
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spillFile queues notifications on disk while a subscription buffer is
+// full, and forwards them to the subscription in order once there is space.
+//
+// Notifications are stored as a 4 byte length, the span context (trace id,
+// span id and flags), then the payload. The file is created when first
+// needed, truncated each time it is emptied, and removed when the
+// subscription is unlistened. It is not read again after a restart, as the
+// events are also in the outbox.
+type spillFile struct {
+	dir  string
+	wake chan struct{} // signals the forwarder that a notification was written
+
+	mu          sync.Mutex
+	file        *os.File
+	readOffset  int64
+	writeOffset int64
+	pending     int // Notifications written and not yet forwarded
+}
+
+// The bytes stored before each payload
+const spillHeaderSize = 4 + 16 + 8 + 1
+
+func newSpillFile(dir string) *spillFile {
+	return &spillFile{dir: dir, wake: make(chan struct{}, 1)}
+}
+
+// send sends the message to the channel if it has space and nothing is
+// spilled, else appends it to the file, so notifications stay in order
+func (s *spillFile) send(listenChan chan Message, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == 0 {
+		select {
+		case listenChan <- msg:
+			return nil
+		default:
+		}
+	}
+
+	if s.file == nil {
+		file, err := os.CreateTemp(s.dir, "notifications-*.spill")
+		if err != nil {
+			return fmt.Errorf("failed to create spill file: %w", err)
+		}
+		s.file = file
+	}
+
+	traceId, spanId := msg.SpanContext.TraceID(), msg.SpanContext.SpanID()
+	record := binary.BigEndian.AppendUint32(nil, uint32(len(msg.Payload)))
+	record = append(record, traceId[:]...)
+	record = append(record, spanId[:]...)
+	record = append(record, byte(msg.SpanContext.TraceFlags()))
+	record = append(record, msg.Payload...)
+	if _, err := s.file.WriteAt(record, s.writeOffset); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	s.writeOffset += int64(len(record))
+	s.pending++
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// forward sends spilled notifications to the channel in order, blocking
+// while it is full, until done is closed
+func (s *spillFile) forward(listenChan chan Message, done <-chan struct{}) error {
+	for {
+		msg, err := s.peek()
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			select {
+			case <-s.wake:
+				continue
+			case <-done:
+				return nil
+			}
+		}
+
+		select {
+		case listenChan <- *msg:
+			s.pop(len(msg.Payload))
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// peek returns the next spilled notification, or nil if there is none
+func (s *spillFile) peek() (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == 0 {
+		return nil, nil
+	}
+
+	var header [spillHeaderSize]byte
+	if _, err := s.file.ReadAt(header[:], s.readOffset); err != nil {
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[:4]))
+	if _, err := s.file.ReadAt(payload, s.readOffset+spillHeaderSize); err != nil {
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID(header[4:20]),
+		SpanID:     trace.SpanID(header[20:28]),
+		TraceFlags: trace.TraceFlags(header[28]),
+	})
+	return &Message{Payload: payload, SpanContext: spanContext}, nil
+}
+
+// pop removes the notification returned by peek, once it was forwarded.
+// Until then, new notifications are spilled after it, to keep them in order.
+func (s *spillFile) pop(length int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readOffset += spillHeaderSize + int64(length)
+	s.pending--
+	if s.pending == 0 {
+		// Reuse the file from the start
+		_ = s.file.Truncate(0)
+		s.readOffset, s.writeOffset = 0, 0
+	}
+}
+
+// close removes the file, discarding any spilled notifications
+func (s *spillFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	err := os.Remove(s.file.Name())
+	s.file = nil
+	s.pending = 0
+	return err
+}
@@ -3,101 +3,99 @@ package db
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // Example parsed JSON
-// {"action":"entity.update.version","actorId":1,"details":{"entityDefId":1001,...},"dml_action":"INSERT"}}
+// {"action":"entity.update.version","actorId":1,"details":{"entityDefId":1001,...},"dml_action":"INSERT","outboxId":1}
+
+// The audit actions that trigger an event. The event only contains the audit
+// row, with the full data (e.g. submission XML) fetched by EnrichEvent.
+var supportedActions = []string{
+	"entity.create",
+	"entity.bulk.create",
+	"entity.update.version",
+	"entity.update.resolve",
+	"entity.delete",
+	"entity.restore",
+	"submission.create",
+	"submission.update",
+	"submission.update.version",
+	"submission.delete",
+	"submission.restore",
+	"submission.purge",
+	"submission.attachment.update",
+	"form.create",
+	"form.update.publish",
+	"form.update.draft.set",
+	"form.delete",
+	"dataset.create",
+	"dataset.update",
+}
 
 func CreateTrigger(ctx context.Context, dbPool *pgxpool.Pool, tableName string) error {
 	// This trigger runs on the `audits` table by default, and creates a new event
-	// in the odk-events queue when a new event is created in the table
+	// in the odk-events queue when a new event is created in the table.
+	// Each event is also written to the webhook_outbox table, so that events
+	// are not lost if nothing is listening at the time.
 
 	if tableName == "" {
 		// default table (this is configurable for easier tests mainly)
 		tableName = "audits"
 	}
 
+	quotedActions := make([]string, len(supportedActions))
+	for i, action := range supportedActions {
+		quotedActions[i] = "'" + action + "'"
+	}
+
 	// SQL for creating the function
-	createFunctionSQL := `
+	createFunctionSQL := fmt.Sprintf(`
 		CREATE OR REPLACE FUNCTION new_audit_log() RETURNS trigger AS
 		$$
 		DECLARE
 			js jsonb;
-			action_type text;
-			result_data jsonb;
+			outbox_id bigint;
 		BEGIN
+			-- Skip pg_notify for unsupported actions & insert as normal
+			IF NOT (NEW.action = ANY(ARRAY[%s])) THEN
+				RETURN NEW;
+			END IF;
+
 			-- Serialize the NEW row into JSONB
 			SELECT to_jsonb(NEW.*) INTO js;
 
 			-- Add the DML action (INSERT/UPDATE)
 			js := jsonb_set(js, '{dml_action}', to_jsonb(TG_OP));
 
-			-- Extract the action type from the NEW row
-			action_type := NEW.action;
-
-			-- Handle different action types with a CASE statement
-			CASE action_type
-				WHEN 'entity.update.version' THEN
-					SELECT entity_defs.data
-					INTO result_data
-					FROM entity_defs
-					WHERE entity_defs.id = (NEW.details->>'entityDefId')::int;
-
-					-- Merge the entity details into the JSON data key
-					js := jsonb_set(js, '{data}', result_data, true);
-
-					-- Truncate if payload is too large: https://github.com/hotosm/central-webhook/issues/8
-					IF length(js::text) > 8000 THEN
-						RAISE NOTICE 'Payload too large, truncating: %', left(js::text, 500) || '...';
-						js := jsonb_set(js, '{truncated}', 'true'::jsonb, true);
-						js := jsonb_set(js, '{data}', '"Payload too large. Truncated."'::jsonb, true);
-					END IF;
-
-					-- Notify the odk-events queue
-					PERFORM pg_notify('odk-events', js::text);
-
-        WHEN 'submission.create' THEN
-            SELECT jsonb_build_object('xml', submission_defs.xml)
-            INTO result_data
-            FROM submission_defs
-            WHERE submission_defs.id = (NEW.details->>'submissionDefId')::int;
-
-        WHEN 'submission.update' THEN
-            SELECT jsonb_build_object('instanceId', submission_defs."instanceId")
-            INTO result_data
-            FROM submission_defs
-            WHERE submission_defs.id = (NEW.details->>'submissionDefId')::int;
-
-            -- Extract 'reviewState' from 'details' and set it in 'data'
-            js := jsonb_set(js, '{data}', jsonb_build_object('reviewState', js->'details'->>'reviewState'), true);
-
-            -- Remove 'reviewState' from 'details'
-            js := jsonb_set(js, '{details}', (js->'details')::jsonb - 'reviewState', true);
-
-					-- Merge the instanceId into the existing 'details' key in JSON
-            js := jsonb_set(js, '{details}', (js->'details') || result_data, true);
-
-					-- Truncate if payload is too large: https://github.com/hotosm/central-webhook/issues/8
-            IF length(js::text) > 8000 THEN
-                RAISE NOTICE 'Payload too large, truncating: %', left(js::text, 500) || '...';
-                js := jsonb_set(js, '{truncated}', 'true'::jsonb, true);
-                js := jsonb_set(js, '{data}', '"Payload too large. Truncated."'::jsonb, true);
-            END IF;
-
-					-- Notify the odk-events queue
-					PERFORM pg_notify('odk-events', js::text);
-
-        ELSE
-					-- Skip pg_notify for unsupported actions & insert as normal
-					RETURN NEW;
-    END CASE;
+			-- Store new events in the outbox, so they can be delivered later
+			-- if the webhook service is not currently listening. Updates
+			-- (e.g. Central setting processed or loggedAt) are only notified,
+			-- so the event is not delivered again on startup.
+			IF TG_OP = 'INSERT' THEN
+				INSERT INTO webhook_outbox (action, payload)
+				VALUES (NEW.action, js)
+				RETURNING id INTO outbox_id;
+
+				js := jsonb_set(js, '{outboxId}', to_jsonb(outbox_id), true);
+			END IF;
+
+			-- The event only contains identifiers, with the data fetched by the
+			-- webhook service, so should be well within the 8000 byte pg_notify
+			-- limit. If not, send only the outbox reference to load it from.
+			IF length(js::text) > 8000 AND outbox_id IS NOT NULL THEN
+				js := jsonb_build_object('action', NEW.action, 'outboxId', outbox_id, 'truncated', true);
+			END IF;
+
+			-- Notify the odk-events queue
+			PERFORM pg_notify('odk-events', js::text);
 
 			RETURN NEW;
-END;
+		END;
 		$$ LANGUAGE 'plpgsql';
-	`
+	`, strings.Join(quotedActions, ", "))
 
 	// SQL for dropping the existing trigger
 	dropTriggerSQL := fmt.Sprintf(`
@@ -120,6 +118,9 @@ END;
 	}
 	defer conn.Release()
 
+	if _, err := conn.Exec(ctx, createOutboxSQL); err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
 	if _, err := conn.Exec(ctx, createFunctionSQL); err != nil {
 		return fmt.Errorf("failed to create function: %w", err)
 	}
@@ -132,3 +133,23 @@ END;
 
 	return err
 }
+
+// TriggerExists reports whether the trigger created by CreateTrigger exists
+// on the table, e.g. it may be removed by a Central upgrade
+func TriggerExists(ctx context.Context, dbPool *pgxpool.Pool, tableName string) (bool, error) {
+	if tableName == "" {
+		tableName = "audits"
+	}
+
+	var exists bool
+	err := dbPool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM pg_trigger
+			WHERE tgname = 'new_audit_log_trigger' AND tgrelid = to_regclass($1)
+		);
+	`, tableName).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check trigger on %s: %w", tableName, err)
+	}
+	return exists, nil
+}
@@ -142,16 +142,18 @@ func TestEntityTrigger(t *testing.T) {
 		<-sub.EstablishedC()
 		msg := <-sub.NotificationC() // Get the notification
 
-		log.Info("notification received", "raw", msg)
+		log.Info("notification received", "raw", string(msg.Payload))
 
-		out <- string(msg) // Send it to the output channel
+		out <- string(msg.Payload) // Send it to the output channel
 		close(out)
 		wg.Done()
 	}()
 
 	// Process the notification
+	var rawNotification string
 	var notification map[string]interface{}
 	for msg := range out {
+		rawNotification = msg
 		err := json.Unmarshal([]byte(msg), &notification)
 		is.NoErr(err) // Ensure the JSON payload is valid
 		log.Info("parsed notification", "notification", notification)
@@ -161,15 +163,23 @@ func TestEntityTrigger(t *testing.T) {
 	is.Equal(notification["dml_action"], "INSERT")            // Ensure action is correct
 	is.Equal(notification["action"], "entity.update.version") // Ensure action is correct
 	is.True(notification["details"] != nil)                   // Ensure details key exists
-	is.True(notification["data"] != nil)                      // Ensure data key exists
+	is.True(notification["data"] == nil)                      // Ensure data is not sent in the notification
+	is.True(notification["outboxId"] != nil)                  // Ensure outboxId key exists
 
 	// Check nested JSON value for entityDefId in details
 	details, ok := notification["details"].(map[string]interface{})
 	is.True(ok)                                     // Ensure details is a valid map
 	is.Equal(details["entityDefId"], float64(1001)) // Ensure entityDefId has the correct value
 
+	// Fetch the full event data
+	enrichedPayload, err := EnrichEvent(ctx, pool, []byte(rawNotification))
+	is.NoErr(err)
+	var event map[string]interface{}
+	err = json.Unmarshal(enrichedPayload, &event)
+	is.NoErr(err)
+
 	// Check nested JSON value for status in data
-	data, ok := notification["data"].(map[string]interface{})
+	data, ok := event["data"].(map[string]interface{})
 	is.True(ok)                   // Ensure data is a valid map
 	is.Equal(data["status"], "0") // Ensure `status` has the correct value
 
@@ -262,16 +272,18 @@ func TestNewSubmissionTrigger(t *testing.T) {
 		<-sub.EstablishedC()
 		msg := <-sub.NotificationC() // Get the notification
 
-		log.Info("notification received", "raw", msg)
+		log.Info("notification received", "raw", string(msg.Payload))
 
-		out <- string(msg) // Send it to the output channel
+		out <- string(msg.Payload) // Send it to the output channel
 		close(out)
 		wg.Done()
 	}()
 
 	// Process the notification
+	var rawNotification string
 	var notification map[string]interface{}
 	for msg := range out {
+		rawNotification = msg
 		err := json.Unmarshal([]byte(msg), &notification)
 		is.NoErr(err) // Ensure the JSON payload is valid
 		log.Info("parsed notification", "notification", notification)
@@ -281,14 +293,22 @@ func TestNewSubmissionTrigger(t *testing.T) {
 	is.Equal(notification["dml_action"], "INSERT")        // Ensure action is correct
 	is.Equal(notification["action"], "submission.create") // Ensure action is correct
 	is.True(notification["details"] != nil)               // Ensure details key exists
-	is.True(notification["data"] != nil)                  // Ensure data key exists
+	is.True(notification["data"] == nil)                  // Ensure data is not sent in the notification
+	is.True(notification["outboxId"] != nil)              // Ensure outboxId key exists
 
 	// Check nested JSON value for submissionDefId in details
 	details, ok := notification["details"].(map[string]interface{})
 	is.True(ok)                                      // Ensure details is a valid map
 	is.Equal(details["submissionDefId"], float64(1)) // Ensure submissionDefId has the correct value
 
-	data, ok := notification["data"].(map[string]interface{})
+	// Fetch the full event data
+	enrichedPayload, err := EnrichEvent(ctx, pool, []byte(rawNotification))
+	is.NoErr(err)
+	var event map[string]interface{}
+	err = json.Unmarshal(enrichedPayload, &event)
+	is.NoErr(err)
+
+	data, ok := event["data"].(map[string]interface{})
 	is.True(ok)                              // Ensure data is a valid map
 	is.Equal(data["xml"], `<data id="xxx">`) // Ensure `xml` has the correct value
 
@@ -300,8 +320,8 @@ func TestNewSubmissionTrigger(t *testing.T) {
 	wg.Wait()
 }
 
-// Test submission truncation works correctly
-func TestNewSubmissionTrigger_TruncatesLargePayload(t *testing.T) {
+// Test large submissions are not truncated
+func TestNewSubmissionTrigger_LargePayload(t *testing.T) {
 	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
 	if len(dbUri) == 0 {
 		// Default
@@ -381,26 +401,39 @@ func TestNewSubmissionTrigger_TruncatesLargePayload(t *testing.T) {
 		<-sub.EstablishedC()
 		msg := <-sub.NotificationC() // Get the notification
 
-		log.Info("notification received", "raw", msg)
+		log.Info("notification received", "raw", string(msg.Payload))
 
-		out <- string(msg) // Send it to the output channel
+		out <- string(msg.Payload) // Send it to the output channel
 		close(out)
 		wg.Done()
 	}()
 
 	// Process the notification
+	var rawNotification string
 	var notification map[string]interface{}
 	for msg := range out {
+		rawNotification = msg
 		err := json.Unmarshal([]byte(msg), &notification)
 		is.NoErr(err) // Ensure the JSON payload is valid
 		log.Info("parsed notification", "notification", notification)
 	}
 
-	// Assert truncation
-	is.Equal(notification["truncated"], true)
-	is.Equal(notification["data"], "Payload too large. Truncated.")
+	// The notification only contains identifiers, so is not truncated
+	is.Equal(notification["truncated"], nil)
 	is.Equal(notification["action"], "submission.create")
 
+	// Fetch the full event data
+	enrichedPayload, err := EnrichEvent(ctx, pool, []byte(rawNotification))
+	is.NoErr(err)
+	var event map[string]interface{}
+	err = json.Unmarshal(enrichedPayload, &event)
+	is.NoErr(err)
+
+	// The full submission XML is fetched
+	data, ok := event["data"].(map[string]interface{})
+	is.True(ok)
+	is.Equal(data["xml"], largeXml)
+
 	// Cleanup
 	conn.Exec(ctx, `DROP TABLE IF EXISTS submission_defs, audits_test CASCADE;`)
 	cancel()
@@ -484,16 +517,18 @@ func TestReviewSubmissionTrigger(t *testing.T) {
 		<-sub.EstablishedC()
 		msg := <-sub.NotificationC() // Get the notification
 
-		log.Info("notification received", "raw", msg)
+		log.Info("notification received", "raw", string(msg.Payload))
 
-		out <- string(msg) // Send it to the output channel
+		out <- string(msg.Payload) // Send it to the output channel
 		close(out)
 		wg.Done()
 	}()
 
 	// Process the notification
+	var rawNotification string
 	var notification map[string]interface{}
 	for msg := range out {
+		rawNotification = msg
 		err := json.Unmarshal([]byte(msg), &notification)
 		is.NoErr(err) // Ensure the JSON payload is valid
 		log.Info("parsed notification", "notification", notification)
@@ -503,16 +538,24 @@ func TestReviewSubmissionTrigger(t *testing.T) {
 	is.Equal(notification["dml_action"], "INSERT")        // Ensure action is correct
 	is.Equal(notification["action"], "submission.update") // Ensure action is correct
 	is.True(notification["details"] != nil)               // Ensure details key exists
-	is.True(notification["data"] != nil)                  // Ensure data key exists
+	is.True(notification["outboxId"] != nil)              // Ensure outboxId key exists
+
+	// Fetch the full event data
+	enrichedPayload, err := EnrichEvent(ctx, pool, []byte(rawNotification))
+	is.NoErr(err)
+	var event map[string]interface{}
+	err = json.Unmarshal(enrichedPayload, &event)
+	is.NoErr(err)
 
 	// Check nested JSON value for submissionDefId
-	details, ok := notification["details"].(map[string]interface{})
+	details, ok := event["details"].(map[string]interface{})
 	is.True(ok)                                                             // Ensure details is a valid map
 	is.Equal(details["submissionDefId"], float64(1))                        // Ensure submissionDefId has the correct value
 	is.Equal(details["instanceId"], "33448049-0df1-4426-9392-d3a294d638ad") // Ensure instanceId has the correct value
+	is.Equal(details["reviewState"], nil)                                   // Ensure reviewState was moved to data
 
 	// Check reviewState present in data key
-	data, ok := notification["data"].(map[string]interface{})
+	data, ok := event["data"].(map[string]interface{})
 	is.True(ok)                               // Ensure data is a valid map
 	is.Equal(data["reviewState"], "approved") // Ensure reviewState has the correct value
 
@@ -577,9 +620,9 @@ func TestNoTrigger(t *testing.T) {
 		<-sub.EstablishedC()
 		msg := <-sub.NotificationC() // Get the notification
 
-		log.Info("notification received", "raw", msg)
+		log.Info("notification received", "raw", string(msg.Payload))
 
-		out <- string(msg) // Send it to the output channel
+		out <- string(msg.Payload) // Send it to the output channel
 		close(out)
 	}()
 
@@ -599,3 +642,39 @@ func TestNoTrigger(t *testing.T) {
 	sub.Unlisten(ctx) // uses background ctx anyway
 	listener.Close(ctx)
 }
+
+func TestTriggerExists(t *testing.T) {
+	dbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
+	if len(dbUri) == 0 {
+		// Default
+		dbUri = "postgresql://odk:odk@db:5432/odk?sslmode=disable"
+	}
+
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx := context.Background()
+	pool, err := InitPool(ctx, log, dbUri)
+	is.NoErr(err)
+
+	conn, err := pool.Acquire(ctx)
+	is.NoErr(err)
+	defer conn.Release()
+	createAuditTestsTable(ctx, conn, is)
+	defer conn.Exec(ctx, `DROP TABLE IF EXISTS submission_defs, audits_test CASCADE;`)
+
+	exists, err := TriggerExists(ctx, pool, "audits_test")
+	is.NoErr(err)
+	is.True(!exists)
+
+	is.NoErr(CreateTrigger(ctx, pool, "audits_test"))
+	exists, err = TriggerExists(ctx, pool, "audits_test")
+	is.NoErr(err)
+	is.True(exists)
+
+	// Removed, e.g. by a Central upgrade
+	_, err = conn.Exec(ctx, `DROP TRIGGER new_audit_log_trigger ON audits_test;`)
+	is.NoErr(err)
+	exists, err = TriggerExists(ctx, pool, "audits_test")
+	is.NoErr(err)
+	is.True(!exists)
+}
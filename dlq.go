@@ -0,0 +1,269 @@
+// The dlq subcommand, for managing events that failed delivery
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/hotosm/central-webhook/config"
+	"github.com/hotosm/central-webhook/db"
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+const dlqUsage = `Usage: centralwebhook dlq <command> [flags] [id...]
+
+Manage events that could not be delivered to a webhook after all retries.
+
+Commands:
+  list     List dead lettered events
+  replay   Re-deliver dead lettered events, removing them once delivered
+  purge    Remove dead lettered events without delivering them
+`
+
+// runDlq runs the dlq subcommand, returning the exit code
+func runDlq(ctx context.Context, args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, dlqUsage)
+		return 1
+	}
+	command := args[0]
+
+	flags := flag.NewFlagSet("dlq "+command, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, dlqUsage)
+		fmt.Fprint(os.Stderr, "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	var configPath string
+	flags.StringVar(&configPath, "config", os.Getenv("CENTRAL_WEBHOOK_CONFIG"), "YAML or JSON config file, for the database and endpoint headers, auth and retries (replay)")
+
+	var dbUri string
+	flags.StringVar(&dbUri, "db", os.Getenv("CENTRAL_WEBHOOK_DB_URI"), "DB host (postgresql://{user}:{password}@{hostname}/{db}?sslmode=disable)")
+
+	var limit int
+	flags.IntVar(&limit, "limit", 100, "Maximum number of events to list or replay")
+
+	var all bool
+	flags.BoolVar(&all, "all", false, "Purge all events, if no ids are provided")
+
+	var apiKey string
+	flags.StringVar(&apiKey, "apiKey", os.Getenv("CENTRAL_WEBHOOK_API_KEY"), "X-API-Key header value, for autenticating with webhook API (replay)")
+
+	var signingSecrets string
+	flags.StringVar(&signingSecrets, "signingSecret", os.Getenv("CENTRAL_WEBHOOK_SIGNING_SECRET"), "Secret to sign requests with, comma separated for multiple secrets (replay)")
+
+	retryPolicy := webhook.DefaultRetryPolicy()
+	retryFlags(flags, &retryPolicy)
+
+	var debug bool
+	flags.BoolVar(&debug, "debug", false, "Enable debug logging")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	ids, err := parseIds(flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cfg := &config.Config{}
+	if configPath != "" {
+		cfg, err = loadConfig(flags, configPath, &dbUri, &retryPolicy, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	endpoints, err := cfg.WebhookEndpoints(retryPolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	auth, err := defaultAuth(apiKey, signingSecrets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	logLevel := slog.LevelInfo
+	if debug {
+		logLevel = slog.LevelDebug
+	} else if cfg.Log.Level != "" {
+		_ = logLevel.UnmarshalText([]byte(cfg.Log.Level)) // validated on load
+	}
+	log := getLogger(logLevel, cfg.Log.Format)
+
+	if dbUri == "" {
+		fmt.Fprintf(os.Stderr, "DB URI is required\n")
+		flags.Usage()
+		return 1
+	}
+
+	dbPool, err := db.InitPool(ctx, log, dbUri)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not connect to database: %v\n", err)
+		return 1
+	}
+	defer dbPool.Close()
+	defer webhook.CloseSinks()
+
+	if err := db.CreateDeadLetterTable(ctx, dbPool); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	switch command {
+	case "list":
+		deadLetters, err := db.ListDeadLetters(ctx, dbPool, limit, ids...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		printDeadLetters(deadLetters)
+
+	case "replay":
+		deadLetters, err := db.ListDeadLetters(ctx, dbPool, limit, ids...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		failed := 0
+		for _, deadLetter := range deadLetters {
+			if err := replayDeadLetter(log, ctx, dbPool, auth, &retryPolicy, endpoints, deadLetter); err != nil {
+				log.Error("failed to replay dead letter", "deadLetterId", deadLetter.ID, "error", err)
+				failed++
+			}
+		}
+		fmt.Printf("replayed %d events, %d failed\n", len(deadLetters)-failed, failed)
+		if failed > 0 {
+			return 1
+		}
+
+	case "purge":
+		if len(ids) == 0 && !all {
+			fmt.Fprintf(os.Stderr, "provide the ids to purge, or -all to purge all events\n")
+			return 1
+		}
+
+		deleted, err := db.DeleteDeadLetters(ctx, dbPool, ids...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("purged %d events\n", deleted)
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown dlq command: %s\n\n", command)
+		flags.Usage()
+		return 1
+	}
+
+	return 0
+}
+
+// replayDeadLetter re-sends a dead lettered event to its original endpoint,
+// using the headers and auth of the configured endpoint, see findEndpoint.
+// The dead letter is removed if delivered, else updated with the new failure.
+func replayDeadLetter(
+	log *slog.Logger,
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	auth *webhook.Auth,
+	retryPolicy *webhook.RetryPolicy,
+	endpoints []webhook.Endpoint,
+	deadLetter db.DeadLetter,
+) error {
+	var event parser.ProcessedEvent
+	if err := json.Unmarshal(deadLetter.Event, &event); err != nil {
+		return fmt.Errorf("invalid event payload: %w", err)
+	}
+	event.OutboxId = deadLetter.OutboxId
+
+	endpoint := withDefaults(findEndpoint(endpoints, deadLetter), auth, retryPolicy)
+
+	result, err := webhook.Send(log, ctx, endpoint, event)
+	if err != nil {
+		updateErr := db.UpdateDeadLetterAttempt(
+			ctx, dbPool, deadLetter.ID, result.StatusCode, result.ResponseBody, result.Attempts, err.Error(),
+		)
+		if updateErr != nil {
+			log.Error("failed to update dead letter", "deadLetterId", deadLetter.ID, "error", updateErr)
+		}
+		return err
+	}
+
+	_, err = db.DeleteDeadLetters(ctx, dbPool, deadLetter.ID)
+	return err
+}
+
+// findEndpoint returns the configured endpoint a dead letter was sent to, by
+// name, or else the first with the same url, e.g. for dead letters stored
+// before names were. Route urls are not configured, so have no endpoint,
+// and are replayed without any password, as it is not stored.
+func findEndpoint(endpoints []webhook.Endpoint, deadLetter db.DeadLetter) webhook.Endpoint {
+	if deadLetter.EndpointName != "" {
+		for _, configured := range endpoints {
+			if configured.Name == deadLetter.EndpointName {
+				return configured
+			}
+		}
+	}
+	for _, configured := range endpoints {
+		if webhook.RedactURL(configured.URL) == webhook.RedactURL(deadLetter.Endpoint) {
+			return configured
+		}
+	}
+	return webhook.Endpoint{URL: deadLetter.Endpoint}
+}
+
+// printDeadLetters writes the dead letters to stdout as a table
+func printDeadLetters(deadLetters []db.DeadLetter) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tTYPE\tEVENT ID\tENDPOINT\tSTATUS\tATTEMPTS\tERROR")
+	for _, deadLetter := range deadLetters {
+		var event parser.ProcessedEvent
+		// Show the row even if the payload is invalid
+		_ = json.Unmarshal(deadLetter.Event, &event)
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			deadLetter.ID,
+			deadLetter.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			deadLetter.EventType,
+			event.ID,
+			cmp.Or(deadLetter.EndpointName, deadLetter.Endpoint),
+			deadLetter.StatusCode,
+			deadLetter.Attempts,
+			deadLetter.Error,
+		)
+	}
+	w.Flush()
+}
+
+// parseIds parses the positional dead letter id arguments
+func parseIds(args []string) ([]int64, error) {
+	var ids []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid id: %s", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -3,25 +3,46 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	// Registers the message broker sinks, by url scheme
+	_ "github.com/hotosm/central-webhook/broker"
+	"github.com/hotosm/central-webhook/config"
 	"github.com/hotosm/central-webhook/db"
+	"github.com/hotosm/central-webhook/metrics"
 	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/router"
+	"github.com/hotosm/central-webhook/tracing"
 	"github.com/hotosm/central-webhook/webhook"
+	"github.com/hotosm/central-webhook/worker"
 )
 
 func getDefaultLogger(lvl slog.Level) *slog.Logger {
-	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	return getLogger(lvl, "json")
+}
+
+// getLogger returns a logger writing to stdout, as json or text
+func getLogger(lvl slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{
 		AddSource: true,
 		Level:     lvl,
 		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
@@ -34,15 +55,348 @@ func getDefaultLogger(lvl slog.Level) *slog.Logger {
 			}
 			return a
 		},
-	}))
+	}
+
+	if strings.ToLower(format) == "text" {
+		return slog.New(slog.NewTextHandler(os.Stdout, opts))
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, opts))
+}
+
+// WebhookUrls are the webhook urls to call for each type of event.
+// Event types with an empty url are not sent.
+//
+// These are converted to routes, and kept for the per-event-type url flags.
+type WebhookUrls struct {
+	UpdateEntity     string // entity.update.version
+	Entity           string // entity.create, entity.bulk.create, entity.delete, entity.restore, entity.update.resolve
+	NewSubmission    string // submission.create
+	ReviewSubmission string // submission.update
+	Submission       string // submission.update.version, submission.delete, submission.restore, submission.purge, submission.attachment.update
+	Form             string // form.create, form.update.publish, form.update.draft.set, form.delete, dataset.create, dataset.update
+}
+
+// routes returns a route for each webhook url that is set
+func (urls WebhookUrls) routes() []router.Route {
+	candidates := []struct {
+		name   string
+		url    string
+		events []string
+	}{
+		{"updateEntityUrl", urls.UpdateEntity, []string{"entity.update.version"}},
+		{"entityUrl", urls.Entity, []string{"entity.create", "entity.bulk.create", "entity.delete", "entity.restore", "entity.update.resolve"}},
+		{"newSubmissionUrl", urls.NewSubmission, []string{"submission.create"}},
+		{"reviewSubmissionUrl", urls.ReviewSubmission, []string{"submission.update"}},
+		{"submissionUrl", urls.Submission, []string{"submission.update.version", "submission.delete", "submission.restore", "submission.purge", "submission.attachment.update"}},
+		{"formUrl", urls.Form, []string{"form.create", "form.update.publish", "form.update.draft.set", "form.delete", "dataset.create", "dataset.update"}},
+	}
+
+	var routes []router.Route
+	for _, candidate := range candidates {
+		if candidate.url == "" {
+			continue
+		}
+		routes = append(routes, router.Route{
+			Name:      candidate.name,
+			Events:    candidate.events,
+			Endpoints: []string{candidate.url},
+		})
+	}
+	return routes
+}
+
+// routeFlags collects the repeatable -route flag
+type routeFlags []router.Route
+
+func (r *routeFlags) String() string {
+	return fmt.Sprintf("%d routes", len(*r))
+}
+
+func (r *routeFlags) Set(value string) error {
+	route, err := router.ParseRoute(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, route)
+	return nil
+}
+
+// parseRoutes parses routes separated by semicolons, e.g. from an environment variable
+func parseRoutes(s string) ([]router.Route, error) {
+	var routes []router.Route
+	for _, value := range strings.Split(s, ";") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		route, err := router.ParseRoute(value)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// parseEvent fetches the full data for an audit event (from a notification or
+// the outbox) and parses it into the event sent to the webhook
+func parseEvent(
+	log *slog.Logger,
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	payload []byte,
+) (*parser.ProcessedEvent, error) {
+	// Notifications too large for pg_notify only reference the outbox event
+	var ref struct {
+		OutboxId  int64 `json:"outboxId"`
+		Truncated bool  `json:"truncated"`
+	}
+	if err := json.Unmarshal(payload, &ref); err == nil && ref.Truncated && ref.OutboxId != 0 {
+		outboxEvent, err := db.GetOutboxEvent(ctx, dbPool, ref.OutboxId)
+		if err != nil {
+			return nil, err
+		}
+		payload = outboxEvent.Payload
+	}
+
+	enrichedPayload, err := db.EnrichEvent(ctx, dbPool, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedData, err := parser.ParseEventJson(log, ctx, enrichedPayload)
+	if err != nil {
+		metrics.ParseFailures.Inc()
+		return nil, err
+	}
+	if ref.OutboxId != 0 {
+		parsedData.OutboxId = ref.OutboxId
+	}
+
+	return parsedData, nil
+}
+
+// deliverEvent sends the event to the endpoints of all matching routes, on
+// the worker pool. Deliveries of events with the same id (the entity uuid or
+// submission instanceId) to the same endpoint are made one at a time, in
+// order, while other deliveries are made concurrently.
+//
+// done is called once the event was delivered to all endpoints. Events that
+// permanently fail delivery are stored in the dead letter table per
+// endpoint, to be replayed with `centralwebhook dlq replay`. done is only
+// called with an error if the event could not be delivered or dead lettered
+// for an endpoint, e.g. on shutdown. Events with no matching route are
+// skipped.
+func deliverEvent(
+	log *slog.Logger,
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	auth *webhook.Auth,
+	retryPolicy *webhook.RetryPolicy,
+	routes *router.Router,
+	pool *worker.Pool,
+	event parser.ProcessedEvent,
+	done func(err error),
+) {
+	endpoints := routes.Match(event)
+	if len(endpoints) == 0 {
+		log.Debug(
+			fmt.Sprintf(
+				"%s event type was triggered, but no route matched",
+				event.Type,
+			),
+			"eventType",
+			event.Type,
+		)
+		done(nil)
+		return
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	remaining := len(endpoints)
+	finish := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+		if remaining--; remaining == 0 {
+			done(errors.Join(errs...))
+		}
+	}
+
+	for _, endpoint := range endpoints {
+		endpoint = withDefaults(endpoint, auth, retryPolicy)
+		err := pool.Submit(ctx, endpoint.URL+" "+event.ID, func() {
+			finish(deliverToEndpoint(log, ctx, dbPool, endpoint, event))
+		})
+		if err != nil {
+			finish(err)
+		}
+	}
+}
+
+// deliverToEndpoint sends the event to the endpoint, moving it to the dead
+// letter table if delivery permanently fails
+func deliverToEndpoint(
+	log *slog.Logger,
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	endpoint webhook.Endpoint,
+	event parser.ProcessedEvent,
+) error {
+	result, err := webhook.Send(log, ctx, endpoint, event)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+	return deadLetterEvent(log, ctx, dbPool, endpoint, event, result, err)
+}
+
+// withDefaults sets the retry policy of an endpoint without its own, and the
+// auth of endpoints given only by url (not configured by name)
+func withDefaults(endpoint webhook.Endpoint, auth *webhook.Auth, retryPolicy *webhook.RetryPolicy) webhook.Endpoint {
+	if endpoint.Name == "" && endpoint.Auth == nil {
+		endpoint.Auth = auth
+	}
+	if endpoint.RetryPolicy == nil {
+		endpoint.RetryPolicy = retryPolicy
+	}
+	return endpoint
+}
+
+// deadLetterEvent stores an event that failed delivery in the dead letter table
+func deadLetterEvent(
+	log *slog.Logger,
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	endpoint webhook.Endpoint,
+	event parser.ProcessedEvent,
+	result *webhook.DeliveryResult,
+	deliveryErr error,
+) error {
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	id, err := db.InsertDeadLetter(ctx, dbPool, db.DeadLetter{
+		OutboxId:     event.OutboxId,
+		Endpoint:     webhook.RedactURL(endpoint.URL),
+		EndpointName: endpoint.Name,
+		EventType:    event.Type,
+		Event:        eventJson,
+		StatusCode:   result.StatusCode,
+		ResponseBody: result.ResponseBody,
+		Attempts:     result.Attempts,
+		Error:        deliveryErr.Error(),
+	})
+	if err != nil {
+		log.Error("failed to store dead letter", "outboxId", event.OutboxId, "error", err)
+		return errors.Join(deliveryErr, err)
+	}
+
+	log.Warn("event moved to dead letter queue", "deadLetterId", id, "outboxId", event.OutboxId, "endpoint", cmp.Or(endpoint.Name, webhook.RedactURL(endpoint.URL)))
+	return nil
+}
+
+// drainOutbox delivers all events in the outbox that were not yet delivered,
+// for example because they were created while the service was not running.
+// Events are delivered concurrently in batches, keeping the order of events
+// with the same id. Draining stops after a batch with an event that could not
+// be delivered or dead lettered, which remains in the outbox for the next drain.
+func drainOutbox(
+	log *slog.Logger,
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	auth *webhook.Auth,
+	retryPolicy *webhook.RetryPolicy,
+	routes *router.Router,
+	pool *worker.Pool,
+	checkpoint *checkpoint,
+) error {
+	const batchSize = 100
+
+	for {
+		events, err := db.PendingOutboxEvents(ctx, dbPool, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		log.Info("delivering pending outbox events", "count", len(events))
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var errs []error
+		for _, outboxEvent := range events {
+			// Each event is traced from the outbox, as from a notification
+			eventCtx, span := tracing.Tracer().Start(ctx, "webhook_outbox receive",
+				trace.WithAttributes(attribute.Int64("webhook.outbox.id", outboxEvent.ID)))
+			parsedData, err := parseEvent(log, eventCtx, dbPool, outboxEvent.Payload)
+			span.End()
+			if err != nil {
+				// The event can never be delivered, so do not block the outbox
+				log.Error("failed to parse outbox event, skipping", "outboxId", outboxEvent.ID, "error", err)
+				if err := db.MarkOutboxDelivered(ctx, dbPool, outboxEvent.ID); err != nil {
+					return err
+				}
+				continue
+			}
+
+			parsedData.OutboxId = outboxEvent.ID
+			if !checkpoint.Start(parsedData.AuditId) {
+				// Already delivered from a notification or the audits table
+				log.Debug("event already delivered, skipping", "outboxId", outboxEvent.ID, "auditId", parsedData.AuditId)
+				if err := db.MarkOutboxDelivered(ctx, dbPool, outboxEvent.ID); err != nil {
+					return err
+				}
+				continue
+			}
+
+			wg.Add(1)
+			event := *parsedData
+			deliverEvent(log, eventCtx, dbPool, auth, retryPolicy, routes, pool, event, func(err error) {
+				defer wg.Done()
+				if cursorErr := checkpoint.Done(event.AuditId, event.OccurredAt, err); cursorErr != nil {
+					log.Error("failed to save cursor", "error", cursorErr)
+				}
+				if err == nil {
+					err = db.MarkOutboxDelivered(ctx, dbPool, outboxEvent.ID)
+				} else {
+					err = fmt.Errorf("failed to deliver outbox event %d: %w", outboxEvent.ID, err)
+				}
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			})
+		}
+		wg.Wait()
+
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// DeliveryOptions configures how events are received and delivered
+type DeliveryOptions struct {
+	Concurrency int                // the number of events delivered at the same time
+	Notifier    db.NotifierOptions // buffering of notifications from the database
+	Readiness   *Readiness         // marked ready once listening, or nil
 }
 
 func SetupWebhook(
 	log *slog.Logger,
 	ctx context.Context,
 	dbPool *pgxpool.Pool,
-	apiKey *string, // use a pointer so it's possible to pass 'nil;
-	updateEntityUrl, newSubmissionUrl, reviewSubmissionUrl string,
+	auth *webhook.Auth, // the api key or signing secrets for routes to urls, or nil
+	retryPolicy *webhook.RetryPolicy, // nil to only attempt each request once
+	routes *router.Router, // the endpoints to send each event to
+	delivery DeliveryOptions,
 ) error {
 	// setup the listener
 	listener := db.NewListener(dbPool)
@@ -51,20 +405,77 @@ func SetupWebhook(
 		return err
 	}
 
-	// init the trigger function
-	db.CreateTrigger(ctx, dbPool, "audits")
+	// init the trigger function (and outbox table)
+	if err := db.CreateTrigger(ctx, dbPool, "audits"); err != nil {
+		log.Error("error creating audit trigger", "error", err)
+		return err
+	}
+
+	// init the dead letter table, for events that fail delivery
+	if err := db.CreateDeadLetterTable(ctx, dbPool); err != nil {
+		log.Error("error creating dead letter table", "error", err)
+		return err
+	}
+
+	// load the cursor, of the last event processed before a restart
+	if err := db.CreateCursorTable(ctx, dbPool); err != nil {
+		log.Error("error creating cursor table", "error", err)
+		return err
+	}
+	cursor, err := db.GetCursor(ctx, dbPool, cursorName)
+	if err != nil {
+		log.Error("error loading cursor", "error", err)
+		return err
+	}
+	var lastProcessed db.Cursor
+	if cursor != nil {
+		lastProcessed = *cursor
+	}
+	checkpoint := newCheckpoint(lastProcessed, func(cursor db.Cursor) error {
+		return db.SaveCursor(ctx, dbPool, cursorName, cursor)
+	})
 
 	// setup the notifier
-	notifier := db.NewNotifier(log, listener)
-	go notifier.Run(ctx)
+	notifier := db.NewNotifierWithOptions(log, listener, delivery.Notifier)
+	go func() {
+		// Run reconnects if the connection is lost, so only returns when done
+		if err := notifier.Run(ctx); err != nil && ctx.Err() == nil {
+			log.Error("stopped listening for notifications", "error", err)
+		}
+	}()
 
 	// subscribe to the 'odk-events' channel
 	log.Info("listening to odk-events channel")
 	sub := notifier.Listen("odk-events")
+	delivery.Readiness.set(dbPool, notifier, sub)
+
+	// deliver events concurrently, so a slow endpoint does not delay others
+	pool := worker.NewPool(delivery.Concurrency)
 
 	// indefinitely listen for updates
 	go func() {
+		defer pool.Close()
 		<-sub.EstablishedC()
+
+		// Deliver any events created while the service was not listening
+		err := drainOutbox(log, ctx, dbPool, auth, retryPolicy, routes, pool, checkpoint)
+		if err != nil {
+			log.Error("failed to drain outbox", "error", err)
+		}
+
+		// Then any events after the cursor not in the outbox, e.g. if the
+		// trigger was removed by a Central upgrade while not running
+		if cursor != nil {
+			log.Info("catching up from the audits table", "auditId", cursor.AuditId, "loggedAt", cursor.LoggedAt)
+			filter := db.AuditFilter{SinceId: cursor.AuditId + 1}
+			result, err := replayAudits(log, ctx, dbPool, auth, retryPolicy, routes, pool, filter, router.Route{}, false, checkpoint)
+			if err != nil {
+				log.Error("failed to catch up from the audits table", "error", err)
+			} else if result.Delivered > 0 || result.Failed > 0 {
+				log.Info("caught up from the audits table", "delivered", result.Delivered, "failed", result.Failed)
+			}
+		}
+
 		for {
 			select {
 			case <-ctx.Done():
@@ -72,35 +483,68 @@ func SetupWebhook(
 				log.Info("done listening for notifications")
 				return
 
-			case data := <-sub.NotificationC():
-				eventData := string(data)
+			case <-sub.ReconnectedC():
+				// Notifications sent while disconnected are lost, but the
+				// trigger still wrote their events to the outbox. Wait for
+				// events in flight first, so they are not delivered twice.
+				pool.Wait()
+				log.Info("delivering events missed while disconnected")
+				err := drainOutbox(log, ctx, dbPool, auth, retryPolicy, routes, pool, checkpoint)
+				if err != nil {
+					log.Error("failed to drain outbox", "error", err)
+				}
+
+			case msg := <-sub.NotificationC():
+				// Continue the trace of receiving the notification
+				eventCtx := trace.ContextWithSpanContext(ctx, msg.SpanContext)
+				eventData := string(msg.Payload)
 				log.Debug("got notification", "data", eventData)
 
-				parsedData, err := parser.ParseEventJson(log, ctx, []byte(eventData))
+				parsedData, err := parseEvent(log, eventCtx, dbPool, []byte(eventData))
 				if err != nil {
 					log.Error("failed to parse notification", "error", err)
 					continue // Skip processing this notification
 				}
 
 				// Only send the request for correctly parsed (supported) events
-				if parsedData != nil {
-					if parsedData.Type == "entity.update.version" && updateEntityUrl != "" {
-						webhook.SendRequest(log, ctx, updateEntityUrl, *parsedData, apiKey)
-					} else if parsedData.Type == "submission.create" && newSubmissionUrl != "" {
-						webhook.SendRequest(log, ctx, newSubmissionUrl, *parsedData, apiKey)
-					} else if parsedData.Type == "submission.update" && reviewSubmissionUrl != "" {
-						webhook.SendRequest(log, ctx, reviewSubmissionUrl, *parsedData, apiKey)
-					} else {
-						log.Debug(
-							fmt.Sprintf(
-								"%s event type was triggered, but no webhook url was provided",
-								parsedData.Type,
-							),
-							"eventType",
-							parsedData.Type,
-						)
+				if parsedData == nil {
+					continue
+				}
+
+				// Skip events already delivered by an outbox drain
+				if parsedData.OutboxId != 0 {
+					pending, err := db.IsOutboxEventPending(eventCtx, dbPool, parsedData.OutboxId)
+					if err != nil {
+						log.Error("failed to check outbox", "error", err)
+					} else if !pending {
+						log.Debug("event already delivered, skipping", "outboxId", parsedData.OutboxId)
+						continue
 					}
 				}
+
+				// Skip events already started by an outbox drain or catch up
+				if !checkpoint.Start(parsedData.AuditId) {
+					log.Debug("event already delivered, skipping", "auditId", parsedData.AuditId)
+					continue
+				}
+
+				outboxId := parsedData.OutboxId
+				event := *parsedData
+				deliverEvent(log, eventCtx, dbPool, auth, retryPolicy, routes, pool, event, func(err error) {
+					if cursorErr := checkpoint.Done(event.AuditId, event.OccurredAt, err); cursorErr != nil {
+						log.Error("failed to save cursor", "error", cursorErr)
+					}
+					if err != nil {
+						// The event remains in the outbox, for delivery on the next drain
+						log.Error("failed to deliver event", "outboxId", outboxId, "error", err)
+						return
+					}
+					if outboxId != 0 {
+						if err := db.MarkOutboxDelivered(ctx, dbPool, outboxId); err != nil {
+							log.Error("failed to mark event delivered", "error", err)
+						}
+					}
+				})
 			}
 		}
 	}()
@@ -129,6 +573,117 @@ func SetupWebhook(
 	return nil
 }
 
+// defaultAuth returns the auth for endpoints given only by url, from the
+// api key and comma separated signing secrets, or nil if neither are set
+func defaultAuth(apiKey string, signingSecrets string) (*webhook.Auth, error) {
+	auth := &webhook.Auth{ApiKey: apiKey}
+	for _, secret := range strings.Split(signingSecrets, ",") {
+		if secret = strings.TrimSpace(secret); secret == "" {
+			continue
+		}
+		if err := webhook.ValidateSigningSecret(secret); err != nil {
+			return nil, err
+		}
+		auth.SigningSecrets = append(auth.SigningSecrets, secret)
+	}
+
+	if auth.ApiKey == "" && len(auth.SigningSecrets) == 0 {
+		return nil, nil
+	}
+	return auth, nil
+}
+
+// loadConfig loads the config file, overriding the database uri, retry
+// policy and delivery options (if not nil) from the environment. Flags
+// given on the command line are set again after, so they take precedence
+// over the config file.
+func loadConfig(
+	flags *flag.FlagSet,
+	path string,
+	dbUri *string,
+	retryPolicy *webhook.RetryPolicy,
+	delivery *DeliveryOptions,
+) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The values of flags given on the command line, before they are overridden
+	given := map[*flag.Flag]string{}
+	flags.Visit(func(f *flag.Flag) {
+		// Repeatable flags would be added twice
+		switch f.Value.(type) {
+		case *routeFlags, *listFlags:
+		default:
+			given[f] = f.Value.String()
+		}
+	})
+
+	if cfg.Database.URI != "" {
+		*dbUri = cfg.Database.URI
+	}
+	*retryPolicy = cfg.Retry.Apply(*retryPolicy)
+	if delivery != nil {
+		if cfg.Delivery.Concurrency != 0 {
+			delivery.Concurrency = cfg.Delivery.Concurrency
+		}
+		if cfg.Delivery.BufferSize != 0 {
+			delivery.Notifier.BufferSize = cfg.Delivery.BufferSize
+		}
+		if cfg.Delivery.Overflow != "" {
+			delivery.Notifier.Overflow = db.OverflowPolicy(cfg.Delivery.Overflow)
+		}
+		if cfg.Delivery.SpillDir != "" {
+			delivery.Notifier.SpillDir = cfg.Delivery.SpillDir
+		}
+	}
+
+	var errs []error
+	for f, value := range given {
+		errs = append(errs, f.Value.Set(value))
+	}
+	return cfg, errors.Join(errs...)
+}
+
+// urlFlags registers the per-event-type webhook url flags, with defaults
+// from the environment
+func urlFlags(flags *flag.FlagSet, urls *WebhookUrls) {
+	flags.StringVar(&urls.UpdateEntity, "updateEntityUrl", os.Getenv("CENTRAL_WEBHOOK_UPDATE_ENTITY_URL"), "Webhook URL for update entity events")
+	flags.StringVar(&urls.Entity, "entityUrl", os.Getenv("CENTRAL_WEBHOOK_ENTITY_URL"), "Webhook URL for entity create, delete, restore and conflict resolve events")
+	flags.StringVar(&urls.NewSubmission, "newSubmissionUrl", os.Getenv("CENTRAL_WEBHOOK_NEW_SUBMISSION_URL"), "Webhook URL for new submission events")
+	flags.StringVar(&urls.ReviewSubmission, "reviewSubmissionUrl", os.Getenv("CENTRAL_WEBHOOK_REVIEW_SUBMISSION_URL"), "Webhook URL for review submission events")
+	flags.StringVar(&urls.Submission, "submissionUrl", os.Getenv("CENTRAL_WEBHOOK_SUBMISSION_URL"), "Webhook URL for submission edit, delete, restore, purge and attachment events")
+	flags.StringVar(&urls.Form, "formUrl", os.Getenv("CENTRAL_WEBHOOK_FORM_URL"), "Webhook URL for form and dataset create, publish, update and delete events")
+}
+
+// retryFlags registers the retry policy flags, with defaults from the environment
+func retryFlags(flags *flag.FlagSet, retryPolicy *webhook.RetryPolicy) {
+	defaultRetryMaxAttempts := envInt("CENTRAL_WEBHOOK_RETRY_MAX_ATTEMPTS", retryPolicy.MaxAttempts)
+	defaultRetryBaseDelay := envDuration("CENTRAL_WEBHOOK_RETRY_BASE_DELAY", retryPolicy.BaseDelay)
+	defaultRetryMaxDelay := envDuration("CENTRAL_WEBHOOK_RETRY_MAX_DELAY", retryPolicy.MaxDelay)
+
+	flags.IntVar(&retryPolicy.MaxAttempts, "retryMaxAttempts", defaultRetryMaxAttempts, "Maximum attempts per webhook request, including retries (1 to disable retries)")
+	flags.DurationVar(&retryPolicy.BaseDelay, "retryBaseDelay", defaultRetryBaseDelay, "Delay before the first retry, doubling for each subsequent retry")
+	flags.DurationVar(&retryPolicy.MaxDelay, "retryMaxDelay", defaultRetryMaxDelay, "Maximum delay between retries")
+}
+
+// envInt reads an integer environment variable, returning the fallback if unset or invalid
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// envDuration reads a duration environment variable (e.g. 10s), returning the fallback if unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
 func printStartupMsg() {
 	banner := `
    _____           _             _  __          __  _     _                 _    
@@ -145,44 +700,91 @@ func printStartupMsg() {
 func main() {
 	ctx := context.Background()
 
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "dlq":
+			os.Exit(runDlq(ctx, os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(ctx, os.Args[2:]))
+		}
+	}
+
 	// Read environment variables
+	defaultConfigPath := os.Getenv("CENTRAL_WEBHOOK_CONFIG")
 	defaultDbUri := os.Getenv("CENTRAL_WEBHOOK_DB_URI")
-	defaultUpdateEntityUrl := os.Getenv("CENTRAL_WEBHOOK_UPDATE_ENTITY_URL")
-	defaultNewSubmissionUrl := os.Getenv("CENTRAL_WEBHOOK_NEW_SUBMISSION_URL")
-	defaultReviewSubmissionUrl := os.Getenv("CENTRAL_WEBHOOK_REVIEW_SUBMISSION_URL")
+	defaultRoutes := os.Getenv("CENTRAL_WEBHOOK_ROUTES")
 	defaultApiKey := os.Getenv("CENTRAL_WEBHOOK_API_KEY")
+	defaultSigningSecrets := os.Getenv("CENTRAL_WEBHOOK_SIGNING_SECRET")
 	defaultLogLevel := os.Getenv("CENTRAL_WEBHOOK_LOG_LEVEL")
+	defaultConcurrency := envInt("CENTRAL_WEBHOOK_CONCURRENCY", 8)
+	defaultBufferSize := envInt("CENTRAL_WEBHOOK_BUFFER_SIZE", 100)
+	defaultOverflow := os.Getenv("CENTRAL_WEBHOOK_OVERFLOW")
+	defaultSpillDir := os.Getenv("CENTRAL_WEBHOOK_SPILL_DIR")
+	defaultStatusAddr := os.Getenv("CENTRAL_WEBHOOK_STATUS_ADDR")
+	defaultTracing := os.Getenv("CENTRAL_WEBHOOK_TRACING")
+
+	var configPath string
+	flag.StringVar(&configPath, "config", defaultConfigPath, "YAML or JSON config file, for endpoints, routes, retries and logging")
 
 	var dbUri string
 	flag.StringVar(&dbUri, "db", defaultDbUri, "DB host (postgresql://{user}:{password}@{hostname}/{db}?sslmode=disable)")
 
-	var updateEntityUrl string
-	flag.StringVar(&updateEntityUrl, "updateEntityUrl", defaultUpdateEntityUrl, "Webhook URL for update entity events")
-
-	var newSubmissionUrl string
-	flag.StringVar(&newSubmissionUrl, "newSubmissionUrl", defaultNewSubmissionUrl, "Webhook URL for new submission events")
+	var urls WebhookUrls
+	urlFlags(flag.CommandLine, &urls)
 
-	var reviewSubmissionUrl string
-	flag.StringVar(&reviewSubmissionUrl, "reviewSubmissionUrl", defaultReviewSubmissionUrl, "Webhook URL for review submission events")
+	var routes routeFlags
+	flag.Var(&routes, "route", "Route events to webhook URLs, e.g. 'event=submission.*,project=3,url=https://...' (repeatable)")
 
 	var apiKey string
 	flag.StringVar(&apiKey, "apiKey", defaultApiKey, "X-API-Key header value, for autenticating with webhook API")
 
+	var signingSecrets string
+	flag.StringVar(&signingSecrets, "signingSecret", defaultSigningSecrets, "Secret to sign requests with (HMAC-SHA256), comma separated to sign with multiple secrets during rotation")
+
+	retryPolicy := webhook.DefaultRetryPolicy()
+	retryFlags(flag.CommandLine, &retryPolicy)
+
+	var delivery DeliveryOptions
+	flag.IntVar(&delivery.Concurrency, "concurrency", defaultConcurrency, "Number of webhook requests sent at the same time (events for the same entity or submission are always sent in order)")
+	flag.IntVar(&delivery.Notifier.BufferSize, "bufferSize", defaultBufferSize, "Number of database notifications buffered while webhook requests are sent")
+	flag.StringVar((*string)(&delivery.Notifier.Overflow), "overflow", cmp.Or(defaultOverflow, string(db.OverflowBlock)), "When the notification buffer is full: block, drop-newest, drop-oldest or spill (to disk)")
+	flag.StringVar(&delivery.Notifier.SpillDir, "spillDir", defaultSpillDir, "Directory for notifications spilled to disk (default the temp directory)")
+
+	var statusAddr string
+	flag.StringVar(&statusAddr, "statusAddr", defaultStatusAddr, "Address to serve Prometheus /metrics, /healthz and /readyz on, e.g. :9090 (disabled if empty)")
+
+	var tracingExporter string
+	flag.StringVar(&tracingExporter, "tracing", cmp.Or(defaultTracing, string(tracing.ExporterNone)), "Export OpenTelemetry traces: none, stdout or otlp (configured by the OTEL_EXPORTER_OTLP_* variables)")
+
 	var debug bool
 	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
 
 	flag.Parse()
 
+	// Values in the config file override environment variables, but not flags
+	cfg := &config.Config{}
+	if configPath != "" {
+		var err error
+		cfg, err = loadConfig(flag.CommandLine, configPath, &dbUri, &retryPolicy, &delivery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Set logging level
 	var logLevel slog.Level
 	if debug {
 		logLevel = slog.LevelDebug
+	} else if cfg.Log.Level != "" {
+		_ = logLevel.UnmarshalText([]byte(cfg.Log.Level)) // validated on load
 	} else if strings.ToLower(defaultLogLevel) == "debug" {
 		logLevel = slog.LevelDebug
 	} else {
 		logLevel = slog.LevelInfo
 	}
-	log := getDefaultLogger(logLevel)
+	log := getLogger(logLevel, cfg.Log.Format)
 
 	if dbUri == "" {
 		fmt.Fprintf(os.Stderr, "DB URI is required\n")
@@ -190,12 +792,102 @@ func main() {
 		os.Exit(1)
 	}
 
-	if updateEntityUrl == "" && newSubmissionUrl == "" && reviewSubmissionUrl == "" {
-		fmt.Fprintf(os.Stderr, "At least one of updateEntityUrl, newSubmissionUrl, reviewSubmissionUrl is required\n")
+	if !slices.Contains(db.OverflowPolicies, delivery.Notifier.Overflow) {
+		fmt.Fprintf(os.Stderr, "Invalid overflow %q, must be one of block, drop-newest, drop-oldest or spill\n", delivery.Notifier.Overflow)
+		os.Exit(1)
+	}
+
+	exporter, err := tracing.ParseExporter(tracingExporter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	shutdownTracing, err := tracing.Setup(ctx, exporter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	defer func() {
+		// Export the remaining spans
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			log.Error("failed to export traces", "error", err)
+		}
+	}()
+
+	auth, err := defaultAuth(apiKey, signingSecrets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	// Routes from flags, or the environment, are added before the config file
+	// routes, then the per-event-type urls
+	if len(routes) == 0 {
+		envRoutes, err := parseRoutes(defaultRoutes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid CENTRAL_WEBHOOK_ROUTES: %v\n", err)
+			os.Exit(1)
+		}
+		routes = envRoutes
+	}
+	buildRoutes := func(cfg *config.Config) []router.Route {
+		allRoutes := slices.Clone([]router.Route(routes))
+		allRoutes = append(allRoutes, cfg.RouterRoutes()...)
+		return append(allRoutes, urls.routes()...)
+	}
+
+	endpoints, err := cfg.WebhookEndpoints(retryPolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	routeTable := router.New(buildRoutes(cfg), endpoints...)
+	if len(routeTable.Routes()) == 0 {
+		fmt.Fprintf(os.Stderr, "At least one route, or one of updateEntityUrl, entityUrl, newSubmissionUrl, reviewSubmissionUrl, submissionUrl, formUrl is required\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
+	// Reload the routes, endpoints and retry policy when the config file
+	// changes, or on SIGHUP
+	if configPath != "" {
+		startupCfg := cfg
+		go watchConfig(log, ctx, configPath, configPollInterval, func(cfg *config.Config) error {
+			reloadedRoutes := buildRoutes(cfg)
+			if len(reloadedRoutes) == 0 {
+				return errors.New("at least one route is required")
+			}
+			reloadedPolicy, err := reloadRetryPolicy(flag.CommandLine, cfg)
+			if err != nil {
+				return err
+			}
+			reloadedEndpoints, err := cfg.WebhookEndpoints(reloadedPolicy)
+			if err != nil {
+				return err
+			}
+			// Url routes use the reloaded default policy too
+			routeTable.ReplaceWithRetryPolicy(reloadedRoutes, &reloadedPolicy, reloadedEndpoints...)
+			closeIdleConnections(endpoints)
+			if err := webhook.CloseSinks(routeTable.URLs()...); err != nil {
+				log.Warn("failed to close sinks no longer used", "error", err)
+			}
+			warnRestartSettings(log, startupCfg, cfg)
+			endpoints = reloadedEndpoints
+			return nil
+		})
+	}
+
+	if statusAddr != "" {
+		delivery.Readiness = &Readiness{}
+		go func() {
+			if err := serveStatus(log, ctx, statusAddr, delivery.Readiness); err != nil {
+				log.Error("failed to serve status endpoints", "error", err)
+			}
+		}()
+	}
+
 	// Get a connection pool
 	dbPool, err := db.InitPool(ctx, log, dbUri)
 	if err != nil {
@@ -204,7 +896,10 @@ func main() {
 	}
 
 	printStartupMsg()
-	err = SetupWebhook(log, ctx, dbPool, &apiKey, updateEntityUrl, newSubmissionUrl, reviewSubmissionUrl)
+	err = SetupWebhook(log, ctx, dbPool, auth, &retryPolicy, routeTable, delivery)
+	if closeErr := webhook.CloseSinks(); closeErr != nil {
+		log.Warn("failed to close sinks", "error", closeErr)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error setting up webhook: %v", err)
 		os.Exit(1)
@@ -0,0 +1,88 @@
+// Prometheus metrics for receiving and delivering events
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "centralwebhook"
+
+// Registry holds the metrics of the service, with the Go runtime and
+// process metrics
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	NotificationsReceived = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_received_total",
+		Help:      "Notifications received from the database, by channel.",
+	}, []string{"channel"})
+
+	NotificationsDropped = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_dropped_total",
+		Help:      "Notifications dropped because a subscription buffer was full, by channel.",
+	}, []string{"channel"})
+
+	ParseFailures = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "parse_failures_total",
+		Help:      "Events that could not be parsed.",
+	})
+
+	Deliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deliveries_total",
+		Help:      "Events sent to an endpoint, by endpoint and the last response status class (2xx, 4xx, 5xx, ok for sinks other than HTTP, or error if there was no response).",
+	}, []string{"endpoint", "status"})
+
+	Retries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Requests retried after a failure, by endpoint.",
+	}, []string{"endpoint"})
+
+	DeliveryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "delivery_duration_seconds",
+		Help:      "Time to send an event to an endpoint, including retries, by endpoint.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10), // 10ms to ~45m
+	}, []string{"endpoint"})
+
+	EventLag = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_lag_seconds",
+		Help:      "Time from an event being logged by Central to its successful delivery.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10), // 100ms to ~7h
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// StatusClass returns the class of a response status code, e.g. 2xx, or
+// error if there was no response
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestStatusClass(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(StatusClass(200), "2xx")
+	is.Equal(StatusClass(204), "2xx")
+	is.Equal(StatusClass(429), "4xx")
+	is.Equal(StatusClass(503), "5xx")
+	is.Equal(StatusClass(0), "error")
+}
+
+func TestHandler(t *testing.T) {
+	is := is.New(t)
+
+	NotificationsReceived.WithLabelValues("test").Inc()
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	is.NoErr(err)
+	defer resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	body, err := io.ReadAll(resp.Body)
+	is.NoErr(err)
+	is.True(strings.Contains(string(body), `centralwebhook_notifications_received_total{channel="test"} 1`))
+	is.True(strings.Contains(string(body), "go_goroutines"))
+}
@@ -6,6 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/hotosm/central-webhook/tracing"
 )
 
 // ** Entities ** //
@@ -19,34 +25,136 @@ type OdkEntityDetails struct {
 	Entity      OdkEntityRef `json:"entity"`
 	EntityId    int          `json:"entityId"`
 	EntityDefId int          `json:"entityDefId"`
+	ProjectId   int          `json:"projectId"` // Added from the audit acteeId
+}
+
+// OdkEntityCreateDetails is for a single entity created via the API or from a submission
+type OdkEntityCreateDetails struct {
+	Entity      OdkEntityRef `json:"entity"`
+	EntityId    int          `json:"entityId"`
+	EntityDefId int          `json:"entityDefId"`
+	// Only present if the entity was created from a submission
+	SubmissionId    int `json:"submissionId"`
+	SubmissionDefId int `json:"submissionDefId"`
+	ProjectId       int `json:"projectId"` // Added from the audit acteeId
+}
+
+type OdkEntitySource struct {
+	Name string `json:"name"` // The uploaded filename
+	Size int    `json:"size"`
+}
+
+// OdkEntityBulkCreateDetails is for many entities created at once, e.g. a CSV upload
+type OdkEntityBulkCreateDetails struct {
+	SourceId  int             `json:"sourceId"`
+	Count     int             `json:"count"`
+	Source    OdkEntitySource `json:"source"`
+	Dataset   string          `json:"dataset"`   // Added from the audit acteeId
+	ProjectId int             `json:"projectId"` // Added from the audit acteeId
+}
+
+// OdkEntityDeleteDetails is for an entity soft deleted or restored
+type OdkEntityDeleteDetails struct {
+	Entity    OdkEntityRef `json:"entity"`
+	ProjectId int          `json:"projectId"` // Added from the audit acteeId
+}
+
+// OdkEntityResolveDetails is for an entity conflict marked as resolved
+type OdkEntityResolveDetails struct {
+	Entity      OdkEntityRef `json:"entity"`
+	EntityId    int          `json:"entityId"`
+	EntityDefId int          `json:"entityDefId"`
+	ProjectId   int          `json:"projectId"` // Added from the audit acteeId
 }
 
 // ** Submissions ** //
 
+// OdkFormRef identifies the form for submission events (added from the audit acteeId)
+type OdkFormRef struct {
+	ProjectId int    `json:"projectId"`
+	XmlFormId string `json:"xmlFormId"`
+}
+
 type OdkSubmissionDetails struct {
 	InstanceId string `json:"instanceId"` // Use string for UUID, as it may be 'uuid:xxx-xxx'
 	// The submissionId field is present, but it's a database reference only, so we ignore it
 	// SubmissionId    int    `json:"submissionId"`
 	SubmissionDefId int `json:"submissionDefId"`
+	OdkFormRef
+}
+
+// OdkSubmissionAttachmentDetails is for a submission attachment (e.g. photo) upload
+type OdkSubmissionAttachmentDetails struct {
+	InstanceId      string `json:"instanceId"`
+	SubmissionDefId int    `json:"submissionDefId"`
+	Name            string `json:"name"` // The attachment filename
+	OdkFormRef
+}
+
+// OdkSubmissionPurgeDetails is for submissions permanently deleted
+type OdkSubmissionPurgeDetails struct {
+	InstanceId         string `json:"instanceId"` // Only present if a single submission was purged
+	SubmissionsDeleted int    `json:"submissionsDeleted"`
+	OdkFormRef
+}
+
+// ** Forms ** //
+
+// OdkFormDetails is for a form created, published, deleted, or a draft updated
+type OdkFormDetails struct {
+	Version string `json:"version"` // The form version (added from the form definition)
+	OdkFormRef
+}
+
+// ** Datasets ** //
+
+// OdkDatasetDetails is for a dataset (entity list) created or updated
+type OdkDatasetDetails struct {
+	ProjectId int    `json:"projectId"`
+	Dataset   string `json:"dataset"` // The dataset name (added from the audit acteeId)
 }
 
 // ** High level wrapper structs ** //
 
 // OdkAuditLog represents the main structure for the audit log (returned by pg_notify)
 type OdkAuditLog struct {
-	Notes   *string     `json:"notes"` // Pointer to handle null values
-	Action  string      `json:"action"`
-	ActeeID string      `json:"acteeId"` // Use string for UUID
-	ActorID int         `json:"actorId"`
-	Details interface{} `json:"details"` // Use an interface to handle different detail types
-	Data    interface{} `json:"data"`    // Use an interface to handle different data types
+	ID       json.Number `json:"id"`    // The audit row id
+	Notes    *string     `json:"notes"` // Pointer to handle null values
+	Action   string      `json:"action"`
+	ActeeID  string      `json:"acteeId"` // Use string for UUID
+	ActorID  int         `json:"actorId"`
+	Details  interface{} `json:"details"` // Use an interface to handle different detail types
+	Data     interface{} `json:"data"`    // Use an interface to handle different data types
+	LoggedAt time.Time   `json:"loggedAt"`
+	// Added by the trigger, referencing the event copy in the webhook_outbox table
+	OutboxId int64 `json:"outboxId"`
 }
 
 // ProcessedEvent represents the final parsed event structure (to send to the webhook API)
 type ProcessedEvent struct {
-	Type string      `json:"type"` // The event type, entity update or new submission
-	ID   string      `json:"id"`   // Entity UUID or Submission InstanceID
-	Data interface{} `json:"data"` // The actual entity data or wrapped submission XML
+	Type      string      `json:"type"`                // The event type, entity update or new submission
+	ID        string      `json:"id"`                  // Entity UUID or Submission InstanceID
+	Data      interface{} `json:"data"`                // The actual entity data or wrapped submission XML
+	Dataset   string      `json:"dataset,omitempty"`   // The entity list name, for entity and dataset events
+	ProjectId int         `json:"projectId,omitempty"` // The project id, where known
+	XmlFormId string      `json:"xmlFormId,omitempty"` // The form id, for submission and form events
+	Version   string      `json:"version,omitempty"`   // The form version, for form events
+	// The webhook_outbox row for this event, not sent to the webhook API
+	OutboxId int64 `json:"-"`
+	// A stable id for the event, from the audits row, so receivers can skip
+	// events delivered more than once (e.g. retried or replayed)
+	EventId    string    `json:"eventId,omitempty"`
+	OccurredAt time.Time `json:"occurredAt,omitzero"` // When the event was logged by Central
+	// The audits row for this event, 0 if unknown, not sent to the webhook API
+	AuditId int64 `json:"-"`
+}
+
+// EventId returns the event id for an audits row id, or "" if unknown
+func EventId(auditId int64) string {
+	if auditId == 0 {
+		return ""
+	}
+	return fmt.Sprintf("evt_%d", auditId)
 }
 
 // ParseJsonString converts the pg_notify string to OdkAuditLog
@@ -66,6 +174,24 @@ func ParseJsonString(log *slog.Logger, data []byte) (*OdkAuditLog, error) {
 
 // ParseEventJson parses the JSON data and extracts the relevant ID and data fields
 func ParseEventJson(log *slog.Logger, ctx context.Context, data []byte) (*ProcessedEvent, error) {
+	_, span := tracing.Tracer().Start(ctx, "parse event")
+	defer span.End()
+
+	event, err := parseEventJson(log, data)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to parse event")
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.String("central.event.type", event.Type),
+		attribute.Int64("central.audit.id", event.AuditId),
+	)
+	return event, nil
+}
+
+// parseEventJson parses the event for ParseEventJson
+func parseEventJson(log *slog.Logger, data []byte) (*ProcessedEvent, error) {
 	// Convert the raw pg_notify string to an OdkAuditLog
 	rawLog, err := ParseJsonString(log, data)
 	if err != nil {
@@ -73,7 +199,13 @@ func ParseEventJson(log *slog.Logger, ctx context.Context, data []byte) (*Proces
 	}
 
 	// Prepare the result structure
-	var processedEvent ProcessedEvent
+	auditId, _ := rawLog.ID.Int64()
+	processedEvent := ProcessedEvent{
+		EventId:    EventId(auditId),
+		OccurredAt: rawLog.LoggedAt,
+		OutboxId:   rawLog.OutboxId,
+		AuditId:    auditId,
+	}
 
 	// Parse the details field based on the action
 	switch rawLog.Action {
@@ -85,6 +217,57 @@ func ParseEventJson(log *slog.Logger, ctx context.Context, data []byte) (*Proces
 		}
 		processedEvent.Type = "entity.update.version"
 		processedEvent.ID = entityDetails.Entity.Uuid
+		processedEvent.Dataset = entityDetails.Entity.Dataset
+		processedEvent.ProjectId = entityDetails.ProjectId
+		processedEvent.Data = rawLog.Data
+
+	case "entity.create":
+		var entityDetails OdkEntityCreateDetails
+		if err := parseDetails(rawLog.Details, &entityDetails); err != nil {
+			log.Error("failed to parse entity.create details", "error", err)
+			return nil, err
+		}
+		processedEvent.Type = "entity.create"
+		processedEvent.ID = entityDetails.Entity.Uuid
+		processedEvent.Dataset = entityDetails.Entity.Dataset
+		processedEvent.ProjectId = entityDetails.ProjectId
+		processedEvent.Data = rawLog.Data
+
+	case "entity.bulk.create":
+		var bulkDetails OdkEntityBulkCreateDetails
+		if err := parseDetails(rawLog.Details, &bulkDetails); err != nil {
+			log.Error("failed to parse entity.bulk.create details", "error", err)
+			return nil, err
+		}
+		// There is no single entity, so the dataset is used as the id
+		processedEvent.Type = "entity.bulk.create"
+		processedEvent.ID = bulkDetails.Dataset
+		processedEvent.Dataset = bulkDetails.Dataset
+		processedEvent.ProjectId = bulkDetails.ProjectId
+		processedEvent.Data = rawLog.Data
+
+	case "entity.delete", "entity.restore":
+		var deleteDetails OdkEntityDeleteDetails
+		if err := parseDetails(rawLog.Details, &deleteDetails); err != nil {
+			log.Error(fmt.Sprintf("failed to parse %s details", rawLog.Action), "error", err)
+			return nil, err
+		}
+		processedEvent.Type = rawLog.Action
+		processedEvent.ID = deleteDetails.Entity.Uuid
+		processedEvent.Dataset = deleteDetails.Entity.Dataset
+		processedEvent.ProjectId = deleteDetails.ProjectId
+		processedEvent.Data = map[string]interface{}{"deleted": rawLog.Action == "entity.delete"}
+
+	case "entity.update.resolve":
+		var resolveDetails OdkEntityResolveDetails
+		if err := parseDetails(rawLog.Details, &resolveDetails); err != nil {
+			log.Error("failed to parse entity.update.resolve details", "error", err)
+			return nil, err
+		}
+		processedEvent.Type = "entity.update.resolve"
+		processedEvent.ID = resolveDetails.Entity.Uuid
+		processedEvent.Dataset = resolveDetails.Entity.Dataset
+		processedEvent.ProjectId = resolveDetails.ProjectId
 		processedEvent.Data = rawLog.Data
 
 	case "submission.create":
@@ -95,6 +278,8 @@ func ParseEventJson(log *slog.Logger, ctx context.Context, data []byte) (*Proces
 		}
 		processedEvent.Type = "submission.create"
 		processedEvent.ID = submissionDetails.InstanceId
+		processedEvent.ProjectId = submissionDetails.ProjectId
+		processedEvent.XmlFormId = submissionDetails.XmlFormId
 
 		// Parse the raw XML data
 		rawData, ok := rawLog.Data.(map[string]interface{})
@@ -112,8 +297,84 @@ func ParseEventJson(log *slog.Logger, ctx context.Context, data []byte) (*Proces
 		}
 		processedEvent.Type = "submission.update"
 		processedEvent.ID = submissionDetails.InstanceId
+		processedEvent.ProjectId = submissionDetails.ProjectId
+		processedEvent.XmlFormId = submissionDetails.XmlFormId
 		processedEvent.Data = rawLog.Data
 
+	case "submission.update.version":
+		var submissionDetails OdkSubmissionDetails
+		if err := parseDetails(rawLog.Details, &submissionDetails); err != nil {
+			log.Error("failed to parse submission.update.version details", "error", err)
+			return nil, err
+		}
+		processedEvent.Type = "submission.update.version"
+		processedEvent.ID = submissionDetails.InstanceId
+		processedEvent.ProjectId = submissionDetails.ProjectId
+		processedEvent.XmlFormId = submissionDetails.XmlFormId
+		processedEvent.Data = rawLog.Data
+
+	case "submission.delete", "submission.restore":
+		var submissionDetails OdkSubmissionDetails
+		if err := parseDetails(rawLog.Details, &submissionDetails); err != nil {
+			log.Error(fmt.Sprintf("failed to parse %s details", rawLog.Action), "error", err)
+			return nil, err
+		}
+		processedEvent.Type = rawLog.Action
+		processedEvent.ID = submissionDetails.InstanceId
+		processedEvent.ProjectId = submissionDetails.ProjectId
+		processedEvent.XmlFormId = submissionDetails.XmlFormId
+		processedEvent.Data = map[string]interface{}{"deleted": rawLog.Action == "submission.delete"}
+
+	case "submission.purge":
+		var purgeDetails OdkSubmissionPurgeDetails
+		if err := parseDetails(rawLog.Details, &purgeDetails); err != nil {
+			log.Error("failed to parse submission.purge details", "error", err)
+			return nil, err
+		}
+		processedEvent.Type = "submission.purge"
+		processedEvent.ID = purgeDetails.InstanceId
+		processedEvent.ProjectId = purgeDetails.ProjectId
+		processedEvent.XmlFormId = purgeDetails.XmlFormId
+		processedEvent.Data = map[string]interface{}{"submissionsDeleted": purgeDetails.SubmissionsDeleted}
+
+	case "submission.attachment.update":
+		var attachmentDetails OdkSubmissionAttachmentDetails
+		if err := parseDetails(rawLog.Details, &attachmentDetails); err != nil {
+			log.Error("failed to parse submission.attachment.update details", "error", err)
+			return nil, err
+		}
+		processedEvent.Type = "submission.attachment.update"
+		processedEvent.ID = attachmentDetails.InstanceId
+		processedEvent.ProjectId = attachmentDetails.ProjectId
+		processedEvent.XmlFormId = attachmentDetails.XmlFormId
+		processedEvent.Data = map[string]interface{}{"name": attachmentDetails.Name}
+
+	case "form.create", "form.update.publish", "form.update.draft.set", "form.delete":
+		var formDetails OdkFormDetails
+		if err := parseDetails(rawLog.Details, &formDetails); err != nil {
+			log.Error(fmt.Sprintf("failed to parse %s details", rawLog.Action), "error", err)
+			return nil, err
+		}
+		processedEvent.Type = rawLog.Action
+		processedEvent.ID = formDetails.XmlFormId
+		processedEvent.ProjectId = formDetails.ProjectId
+		processedEvent.XmlFormId = formDetails.XmlFormId
+		processedEvent.Version = formDetails.Version
+		processedEvent.Data = map[string]interface{}{"version": formDetails.Version}
+
+	case "dataset.create", "dataset.update":
+		var datasetDetails OdkDatasetDetails
+		if err := parseDetails(rawLog.Details, &datasetDetails); err != nil {
+			log.Error(fmt.Sprintf("failed to parse %s details", rawLog.Action), "error", err)
+			return nil, err
+		}
+		processedEvent.Type = rawLog.Action
+		processedEvent.ID = datasetDetails.Dataset
+		processedEvent.ProjectId = datasetDetails.ProjectId
+		processedEvent.Dataset = datasetDetails.Dataset
+		// The audit details, e.g. the dataset properties or settings changed
+		processedEvent.Data = rawLog.Details
+
 	default:
 		// No nothing if the event type is not supported
 		log.Warn("unsupported action type", "action", rawLog.Action)
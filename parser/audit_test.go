@@ -2,9 +2,12 @@ package parser
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"log/slog"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/matryer/is"
 )
@@ -54,6 +57,128 @@ func TestParseEventJson(t *testing.T) {
 		is.NoErr(err)
 		is.Equal("abc", result.ID)
 		is.Equal(map[string]interface{}{}, result.Data)
+		is.Equal(result.AuditId, int64(123))
+	})
+
+	t.Run("Audit Row", func(t *testing.T) {
+		// As serialized from the audits table by the trigger
+		input := []byte(`{
+			"id":456,
+			"action":"submission.create",
+			"actorId":1,
+			"details":{"instanceId":"uuid:123"},
+			"data":{"xml":"<submission></submission>"},
+			"loggedAt":"2026-10-01T10:00:00.123456+00:00",
+			"outboxId":7
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal(result.AuditId, int64(456))
+		is.Equal(result.EventId, "evt_456")
+		is.True(result.OccurredAt.Equal(time.Date(2026, 10, 1, 10, 0, 0, 123456000, time.UTC)))
+		is.Equal(result.OutboxId, int64(7))
+
+		// The event id and time are sent to the webhook, the audit id is not
+		marshaled, err := json.Marshal(result)
+		is.NoErr(err)
+		is.True(strings.Contains(string(marshaled), `"eventId":"evt_456"`))
+		is.True(strings.Contains(string(marshaled), `"occurredAt":"2026-10-01T10:00:00.123456Z"`))
+		is.True(!strings.Contains(string(marshaled), "auditId"))
+	})
+
+	t.Run("Without Audit Row", func(t *testing.T) {
+		input := []byte(`{
+			"action":"submission.create",
+			"details":{"instanceId":"uuid:123"},
+			"data":{"xml":"<submission></submission>"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal(result.EventId, "")
+
+		// Omitted if unknown
+		marshaled, err := json.Marshal(result)
+		is.NoErr(err)
+		is.True(!strings.Contains(string(marshaled), "eventId"))
+		is.True(!strings.Contains(string(marshaled), "occurredAt"))
+	})
+
+	t.Run("Entity Create", func(t *testing.T) {
+		input := []byte(`{
+			"action":"entity.create",
+			"actorId":1,
+			"details":{
+				"entity":{"uuid":"abc","dataset":"trees"},
+				"entityId":10,
+				"entityDefId":20,
+				"submissionId":30,
+				"submissionDefId":40
+			},
+			"data":{"species":"oak"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("entity.create", result.Type)
+		is.Equal("abc", result.ID)
+		is.Equal("trees", result.Dataset)
+		is.Equal(map[string]interface{}{"species": "oak"}, result.Data)
+	})
+
+	t.Run("Entity Bulk Create", func(t *testing.T) {
+		input := []byte(`{
+			"action":"entity.bulk.create",
+			"actorId":1,
+			"details":{"sourceId":5,"count":2,"source":{"name":"trees.csv","size":100},"dataset":"trees"},
+			"data":{"count":2,"entities":[{"uuid":"abc","data":{}},{"uuid":"def","data":{}}]}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("entity.bulk.create", result.Type)
+		is.Equal("trees", result.ID)
+		is.Equal("trees", result.Dataset)
+
+		data, ok := result.Data.(map[string]interface{})
+		is.True(ok)
+		is.Equal(float64(2), data["count"])
+	})
+
+	t.Run("Entity Delete And Restore", func(t *testing.T) {
+		input := []byte(`{
+			"action":"entity.delete",
+			"actorId":1,
+			"details":{"entity":{"uuid":"abc","dataset":"trees"},"projectId":3}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("entity.delete", result.Type)
+		is.Equal("abc", result.ID)
+		is.Equal("trees", result.Dataset)
+		is.Equal(3, result.ProjectId)
+		is.Equal(map[string]interface{}{"deleted": true}, result.Data)
+
+		input = []byte(`{
+			"action":"entity.restore",
+			"actorId":1,
+			"details":{"entity":{"uuid":"abc","dataset":"trees"}}
+		}`)
+		result, err = ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("entity.restore", result.Type)
+		is.Equal(map[string]interface{}{"deleted": false}, result.Data)
+	})
+
+	t.Run("Entity Update Resolve", func(t *testing.T) {
+		input := []byte(`{
+			"action":"entity.update.resolve",
+			"actorId":1,
+			"details":{"entity":{"uuid":"abc","dataset":"trees"},"entityId":10,"entityDefId":21},
+			"data":{"species":"pine"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("entity.update.resolve", result.Type)
+		is.Equal("abc", result.ID)
+		is.Equal(map[string]interface{}{"species": "pine"}, result.Data)
 	})
 
 	t.Run("Submission Create", func(t *testing.T) {
@@ -89,6 +214,144 @@ func TestParseEventJson(t *testing.T) {
 		is.Equal("approved", wrappedData["reviewState"])
 	})
 
+	t.Run("Outbox Id", func(t *testing.T) {
+		input := []byte(`{
+			"id":"456",
+			"action":"submission.create",
+			"actorId":2,
+			"details":{"instanceId":"sub-123","submissionId":789,"submissionDefId":101112},
+			"data":{"xml":"<submission></submission>"},
+			"outboxId":42
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal(int64(42), result.OutboxId)
+
+		// The outbox id is internal, and not sent to the webhook
+		marshaled, err := json.Marshal(result)
+		is.NoErr(err)
+		is.True(!strings.Contains(string(marshaled), "42"))
+	})
+
+	t.Run("Submission Create With Form", func(t *testing.T) {
+		input := []byte(`{
+			"action":"submission.create",
+			"details":{"instanceId":"sub-123","submissionDefId":101112,"projectId":3,"xmlFormId":"buildings"},
+			"data":{"xml":"<submission></submission>"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal(3, result.ProjectId)
+		is.Equal("buildings", result.XmlFormId)
+	})
+
+	t.Run("Submission Update Version", func(t *testing.T) {
+		input := []byte(`{
+			"action":"submission.update.version",
+			"details":{"instanceId":"sub-456","submissionId":789,"submissionDefId":101113,"projectId":3,"xmlFormId":"buildings"},
+			"data":{"xml":"<submission>edited</submission>"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("submission.update.version", result.Type)
+		is.Equal("sub-456", result.ID)
+		is.Equal(3, result.ProjectId)
+		is.Equal("buildings", result.XmlFormId)
+
+		wrappedData, ok := result.Data.(map[string]interface{})
+		is.True(ok)
+		is.Equal("<submission>edited</submission>", wrappedData["xml"])
+	})
+
+	t.Run("Submission Delete And Restore", func(t *testing.T) {
+		input := []byte(`{
+			"action":"submission.delete",
+			"details":{"instanceId":"sub-456","submissionId":789,"projectId":3,"xmlFormId":"buildings"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("submission.delete", result.Type)
+		is.Equal("sub-456", result.ID)
+		is.Equal("buildings", result.XmlFormId)
+		is.Equal(map[string]interface{}{"deleted": true}, result.Data)
+
+		input = []byte(`{
+			"action":"submission.restore",
+			"details":{"instanceId":"sub-456","submissionId":789,"projectId":3,"xmlFormId":"buildings"}
+		}`)
+		result, err = ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("submission.restore", result.Type)
+		is.Equal(map[string]interface{}{"deleted": false}, result.Data)
+	})
+
+	t.Run("Submission Purge", func(t *testing.T) {
+		input := []byte(`{
+			"action":"submission.purge",
+			"details":{"submissionsDeleted":4,"projectId":3,"xmlFormId":"buildings"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("submission.purge", result.Type)
+		is.Equal(3, result.ProjectId)
+		is.Equal(map[string]interface{}{"submissionsDeleted": 4}, result.Data)
+	})
+
+	t.Run("Submission Attachment Update", func(t *testing.T) {
+		input := []byte(`{
+			"action":"submission.attachment.update",
+			"details":{"instanceId":"sub-456","submissionDefId":101113,"name":"photo.jpg","projectId":3,"xmlFormId":"buildings"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("submission.attachment.update", result.Type)
+		is.Equal("sub-456", result.ID)
+		is.Equal("buildings", result.XmlFormId)
+		is.Equal(map[string]interface{}{"name": "photo.jpg"}, result.Data)
+	})
+
+	t.Run("Form Publish", func(t *testing.T) {
+		input := []byte(`{
+			"action":"form.update.publish",
+			"details":{"oldDefId":1,"newDefId":2,"projectId":3,"xmlFormId":"buildings","version":"v2"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("form.update.publish", result.Type)
+		is.Equal("buildings", result.ID)
+		is.Equal(3, result.ProjectId)
+		is.Equal("buildings", result.XmlFormId)
+		is.Equal("v2", result.Version)
+	})
+
+	t.Run("Form Draft Set", func(t *testing.T) {
+		input := []byte(`{
+			"action":"form.update.draft.set",
+			"details":{"newDraftDefId":4,"projectId":3,"xmlFormId":"buildings","version":"v3-draft"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("form.update.draft.set", result.Type)
+		is.Equal("v3-draft", result.Version)
+	})
+
+	t.Run("Dataset Update", func(t *testing.T) {
+		input := []byte(`{
+			"action":"dataset.update",
+			"details":{"fields":[["age","/age"]],"projectId":3,"dataset":"trees"}
+		}`)
+		result, err := ParseEventJson(log, ctx, input)
+		is.NoErr(err)
+		is.Equal("dataset.update", result.Type)
+		is.Equal("trees", result.ID)
+		is.Equal("trees", result.Dataset)
+		is.Equal(3, result.ProjectId)
+
+		data, ok := result.Data.(map[string]interface{})
+		is.True(ok)
+		is.True(data["fields"] != nil)
+	})
+
 	t.Run("Unsupported Action", func(t *testing.T) {
 		input := []byte(`{
 			"id":"789",
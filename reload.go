@@ -0,0 +1,131 @@
+// Reload the config file while running, without restarting the listener
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hotosm/central-webhook/config"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// How often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// watchConfig calls reload with the config file each time the file changes,
+// or the process receives SIGHUP, until the context is done.
+//
+// The file is polled rather than watched, so changes made by replacing the
+// file or a symlink to it (e.g. a mounted Kubernetes ConfigMap) are detected.
+// An invalid config is logged and ignored, keeping the current config.
+func watchConfig(
+	log *slog.Logger,
+	ctx context.Context,
+	path string,
+	interval time.Duration,
+	reload func(cfg *config.Config) error,
+) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	current := statFile(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			log.Info("received SIGHUP, reloading config", "path", path)
+
+		case <-ticker.C:
+			if statFile(path) == current {
+				continue
+			}
+			log.Info("config file changed, reloading", "path", path)
+		}
+
+		current = statFile(path)
+		cfg, err := config.Load(path)
+		if err == nil {
+			err = reload(cfg)
+		}
+		if err != nil {
+			log.Error("failed to reload config, keeping the current config", "path", path, "error", err)
+			continue
+		}
+		log.Info("config reloaded", "path", path)
+	}
+}
+
+// fileVersion identifies a version of a file, to detect changes
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile returns the current version of the file, or an empty version if
+// it cannot be read
+func statFile(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}
+}
+
+// reloadRetryPolicy returns the default retry policy for a reloaded config,
+// as on startup: the config retry options applied to the defaults, with any
+// retry flags given on the command line taking precedence
+func reloadRetryPolicy(flags *flag.FlagSet, cfg *config.Config) (webhook.RetryPolicy, error) {
+	policy := webhook.DefaultRetryPolicy()
+	defaults := flag.NewFlagSet(flags.Name(), flag.ContinueOnError)
+	retryFlags(defaults, &policy) // the defaults from the environment
+	policy = cfg.Retry.Apply(policy)
+
+	var errs []error
+	flags.Visit(func(f *flag.Flag) {
+		if retryFlag := defaults.Lookup(f.Name); retryFlag != nil {
+			errs = append(errs, retryFlag.Value.Set(f.Value.String()))
+		}
+	})
+	return policy, errors.Join(errs...)
+}
+
+// warnRestartSettings logs a warning if a reloaded config changes settings
+// that are only read at startup, as they are not applied
+func warnRestartSettings(log *slog.Logger, startup, reloaded *config.Config) {
+	var changed []string
+	if startup.Database != reloaded.Database {
+		changed = append(changed, "database")
+	}
+	if startup.Log != reloaded.Log {
+		changed = append(changed, "log")
+	}
+	if startup.Delivery != reloaded.Delivery {
+		changed = append(changed, "delivery")
+	}
+	if len(changed) > 0 {
+		log.Warn("config settings changed that are only read at startup, restart to apply them", "settings", changed)
+	}
+}
+
+// closeIdleConnections closes the idle connections of the endpoints' HTTP
+// clients, once the endpoints are replaced. Requests in flight still finish.
+func closeIdleConnections(endpoints []webhook.Endpoint) {
+	for _, endpoint := range endpoints {
+		if endpoint.Client != nil {
+			endpoint.Client.CloseIdleConnections()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/config"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+func TestWatchConfig(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(url string) {
+		err := os.WriteFile(path, []byte("endpoints:\n  - name: a\n    url: "+url+"\n"), 0o600)
+		is.NoErr(err)
+	}
+	writeConfig("https://a.example.com")
+
+	reloaded := make(chan *config.Config, 1)
+	go watchConfig(log, ctx, path, 10*time.Millisecond, func(cfg *config.Config) error {
+		reloaded <- cfg
+		return nil
+	})
+
+	waitForReload := func() *config.Config {
+		select {
+		case cfg := <-reloaded:
+			return cfg
+		case <-time.After(2 * time.Second):
+			return nil
+		}
+	}
+
+	// Not reloaded until the file changes
+	time.Sleep(50 * time.Millisecond)
+	is.Equal(len(reloaded), 0)
+
+	writeConfig("https://changed.example.com")
+	cfg := waitForReload()
+	is.True(cfg != nil)
+	is.Equal(cfg.Endpoints[0].URL, "https://changed.example.com")
+
+	// Invalid config is ignored
+	writeConfig("not a url")
+	time.Sleep(50 * time.Millisecond)
+	is.Equal(len(reloaded), 0)
+
+	writeConfig("https://fixed.example.com")
+	cfg = waitForReload()
+	is.True(cfg != nil)
+	is.Equal(cfg.Endpoints[0].URL, "https://fixed.example.com")
+}
+
+func TestReloadRetryPolicy(t *testing.T) {
+	is := is.New(t)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	startupPolicy := webhook.DefaultRetryPolicy()
+	retryFlags(flags, &startupPolicy)
+	is.NoErr(flags.Parse([]string{"-retryMaxAttempts", "7"}))
+
+	cfg, err := config.Parse([]byte("retry:\n  maxAttempts: 3\n  baseDelay: 2s\n"))
+	is.NoErr(err)
+
+	// The reloaded config is used, except where flags were given
+	policy, err := reloadRetryPolicy(flags, cfg)
+	is.NoErr(err)
+	is.Equal(policy.MaxAttempts, 7)
+	is.Equal(policy.BaseDelay, 2*time.Second)
+	is.Equal(policy.MaxDelay, webhook.DefaultRetryPolicy().MaxDelay)
+}
+
+func TestWarnRestartSettings(t *testing.T) {
+	is := is.New(t)
+
+	var out bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&out, nil))
+	startup, err := config.Parse([]byte("log:\n  level: info\ndelivery:\n  concurrency: 4\n"))
+	is.NoErr(err)
+
+	// Only settings that need a restart are warned about
+	reloaded, err := config.Parse([]byte("log:\n  level: info\ndelivery:\n  concurrency: 4\nretry:\n  maxAttempts: 3\n"))
+	is.NoErr(err)
+	warnRestartSettings(log, startup, reloaded)
+	is.Equal(out.String(), "")
+
+	reloaded, err = config.Parse([]byte("log:\n  level: debug\ndelivery:\n  concurrency: 8\n"))
+	is.NoErr(err)
+	warnRestartSettings(log, startup, reloaded)
+	is.True(strings.Contains(out.String(), "settings=\"[log delivery]\""))
+}
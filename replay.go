@@ -0,0 +1,363 @@
+// The replay subcommand, for delivering past events from the audit log
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hotosm/central-webhook/config"
+	"github.com/hotosm/central-webhook/db"
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/router"
+	"github.com/hotosm/central-webhook/tracing"
+	"github.com/hotosm/central-webhook/webhook"
+	"github.com/hotosm/central-webhook/worker"
+)
+
+const replayUsage = `Usage: centralwebhook replay -since <time> [flags]
+
+Deliver past events from the Central audit log to the matching routes, e.g.
+to backfill a new endpoint, or recover after an outage. Events are read from
+the audits table and sent the same way as new events, oldest first.
+
+Times are RFC 3339 (2026-10-01T00:00:00Z, 2026-10-01T00:00Z) or a date
+(2026-10-01, as UTC). Audit ids can be used instead, e.g. to resume an
+interrupted replay.
+`
+
+// listFlags collects a repeatable flag, which can also be comma separated
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlags) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// runReplay runs the replay subcommand, returning the exit code
+func runReplay(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, replayUsage)
+		fmt.Fprint(os.Stderr, "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	var configPath string
+	flags.StringVar(&configPath, "config", os.Getenv("CENTRAL_WEBHOOK_CONFIG"), "YAML or JSON config file, for the database, endpoints and routes")
+
+	var dbUri string
+	flags.StringVar(&dbUri, "db", os.Getenv("CENTRAL_WEBHOOK_DB_URI"), "DB host (postgresql://{user}:{password}@{hostname}/{db}?sslmode=disable)")
+
+	var routes routeFlags
+	flags.Var(&routes, "route", "Route events to webhook URLs, e.g. 'event=submission.*,project=3,url=https://...' (repeatable)")
+
+	var urls WebhookUrls
+	urlFlags(flags, &urls)
+
+	var since, until string
+	flags.StringVar(&since, "since", "", "Replay events logged at or after this time")
+	flags.StringVar(&until, "until", "", "Replay events logged before this time (default now)")
+
+	var sinceId, untilId int64
+	flags.Int64Var(&sinceId, "sinceId", 0, "Replay events from this audit id")
+	flags.Int64Var(&untilId, "untilId", 0, "Replay events up to and including this audit id")
+
+	var actions listFlags
+	flags.Var(&actions, "action", "Only replay these event types, with wildcards, e.g. 'submission.*' (repeatable)")
+
+	var projects listFlags
+	flags.Var(&projects, "project", "Only replay events for these project ids (repeatable)")
+
+	var dryRun bool
+	flags.BoolVar(&dryRun, "dryRun", false, "List the events that would be replayed, without delivering them")
+
+	var apiKey string
+	flags.StringVar(&apiKey, "apiKey", os.Getenv("CENTRAL_WEBHOOK_API_KEY"), "X-API-Key header value, for autenticating with webhook API")
+
+	var signingSecrets string
+	flags.StringVar(&signingSecrets, "signingSecret", os.Getenv("CENTRAL_WEBHOOK_SIGNING_SECRET"), "Secret to sign requests with, comma separated for multiple secrets")
+
+	retryPolicy := webhook.DefaultRetryPolicy()
+	retryFlags(flags, &retryPolicy)
+
+	delivery := DeliveryOptions{}
+	flags.IntVar(&delivery.Concurrency, "concurrency", envInt("CENTRAL_WEBHOOK_CONCURRENCY", 8), "Number of webhook requests sent at the same time")
+
+	var debug bool
+	flags.BoolVar(&debug, "debug", false, "Enable debug logging")
+
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	filter, err := replayFilter(since, until, sinceId, untilId, actions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	match := router.Route{Events: actions}
+	for _, project := range projects {
+		projectId, err := strconv.Atoi(project)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid project id: %s\n", project)
+			return 1
+		}
+		match.ProjectIds = append(match.ProjectIds, projectId)
+	}
+
+	cfg := &config.Config{}
+	if configPath != "" {
+		cfg, err = loadConfig(flags, configPath, &dbUri, &retryPolicy, &delivery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	endpoints, err := cfg.WebhookEndpoints(retryPolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(routes) == 0 {
+		routes, err = parseRoutes(os.Getenv("CENTRAL_WEBHOOK_ROUTES"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid CENTRAL_WEBHOOK_ROUTES: %v\n", err)
+			return 1
+		}
+	}
+	// In the same order as the service
+	allRoutes := append([]router.Route(routes), cfg.RouterRoutes()...)
+	allRoutes = append(allRoutes, urls.routes()...)
+	routeTable := router.New(allRoutes, endpoints...)
+	if len(routeTable.Routes()) == 0 {
+		fmt.Fprintf(os.Stderr, "At least one route is required, from -route, CENTRAL_WEBHOOK_ROUTES, the config file, or one of updateEntityUrl, entityUrl, newSubmissionUrl, reviewSubmissionUrl, submissionUrl, formUrl\n")
+		return 1
+	}
+
+	auth, err := defaultAuth(apiKey, signingSecrets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	logLevel := slog.LevelInfo
+	if debug {
+		logLevel = slog.LevelDebug
+	} else if cfg.Log.Level != "" {
+		_ = logLevel.UnmarshalText([]byte(cfg.Log.Level)) // validated on load
+	}
+	log := getLogger(logLevel, cfg.Log.Format)
+
+	if dbUri == "" {
+		fmt.Fprintf(os.Stderr, "DB URI is required\n")
+		flags.Usage()
+		return 1
+	}
+
+	dbPool, err := db.InitPool(ctx, log, dbUri)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not connect to database: %v\n", err)
+		return 1
+	}
+	defer dbPool.Close()
+
+	if err := db.CreateDeadLetterTable(ctx, dbPool); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	// Stop after the events in flight on interrupt, so the replay can be resumed
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Closed after the pool, so after the events in flight are delivered
+	defer webhook.CloseSinks()
+	pool := worker.NewPool(delivery.Concurrency)
+	defer pool.Close()
+
+	result, err := replayAudits(log, ctx, dbPool, auth, &retryPolicy, routeTable, pool, filter, match, dryRun, nil)
+	fmt.Printf("replayed %d events, %d failed\n", result.Delivered, result.Failed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if result.LastId != 0 {
+			fmt.Fprintf(os.Stderr, "resume with -sinceId %d\n", result.LastId+1)
+		}
+		return 1
+	}
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// replayFilter returns the audit filter for the flags, requiring a start
+func replayFilter(since, until string, sinceId, untilId int64, actions []string) (db.AuditFilter, error) {
+	filter := db.AuditFilter{SinceId: sinceId, UntilId: untilId}
+	if since == "" && sinceId == 0 {
+		return filter, errors.New("-since or -sinceId is required")
+	}
+
+	var err error
+	if since != "" {
+		if filter.Since, err = parseTime(since); err != nil {
+			return filter, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if until != "" {
+		if filter.Until, err = parseTime(until); err != nil {
+			return filter, fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+	if !filter.Until.IsZero() && !filter.Until.After(filter.Since) {
+		return filter, errors.New("-until must be after -since")
+	}
+
+	// Only query the supported actions matching the event type patterns
+	if len(actions) > 0 {
+		match := router.Route{Events: actions}
+		for _, action := range db.SupportedActions() {
+			if match.Matches(parser.ProcessedEvent{Type: action}) {
+				filter.Actions = append(filter.Actions, action)
+			}
+		}
+		if len(filter.Actions) == 0 {
+			return filter, fmt.Errorf("no supported event types match %s", strings.Join(actions, ", "))
+		}
+	}
+	return filter, nil
+}
+
+// The time formats accepted by parseTime
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00", time.DateOnly}
+
+// parseTime parses an RFC 3339 time, with optional seconds, or a UTC date
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time, e.g. 2026-10-01T00:00:00Z or 2026-10-01", value)
+}
+
+// replayResult counts the replayed events
+type replayResult struct {
+	Delivered int
+	Failed    int
+	LastId    int64 // The last audit id handled, to resume from
+}
+
+// replayAudits delivers the audit events matching the filter and route,
+// in batches, waiting for each batch before reading the next. Events that
+// permanently fail delivery are dead lettered, as for new events.
+//
+// Events already started in the checkpoint are skipped, and delivered
+// events are marked done. The checkpoint is nil for the replay subcommand,
+// to deliver every event again.
+func replayAudits(
+	log *slog.Logger,
+	ctx context.Context,
+	dbPool *pgxpool.Pool,
+	auth *webhook.Auth,
+	retryPolicy *webhook.RetryPolicy,
+	routes *router.Router,
+	pool *worker.Pool,
+	filter db.AuditFilter,
+	match router.Route,
+	dryRun bool,
+	checkpoint *checkpoint,
+) (replayResult, error) {
+	const batchSize = 100
+
+	var result replayResult
+	for {
+		audits, err := db.ListAudits(ctx, dbPool, filter, result.LastId, batchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(audits) == 0 {
+			return result, nil
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, audit := range audits {
+			if ctx.Err() != nil {
+				break
+			}
+			if !checkpoint.Start(audit.ID) {
+				continue
+			}
+
+			// Each event is traced from the audits table, as from a notification
+			eventCtx, span := tracing.Tracer().Start(ctx, "audits receive",
+				trace.WithAttributes(attribute.Int64("central.audit.id", audit.ID)))
+			parsedData, err := parseEvent(log, eventCtx, dbPool, audit.Payload)
+			span.End()
+			if err != nil {
+				log.Error("failed to parse audit event, skipping", "auditId", audit.ID, "error", err)
+				checkpoint.Done(audit.ID, audit.LoggedAt, err) // so the cursor stays before it, to retry
+				result.Failed++
+				continue
+			}
+			if parsedData == nil || !match.Matches(*parsedData) {
+				if err := checkpoint.Done(audit.ID, audit.LoggedAt, nil); err != nil {
+					log.Error("failed to save cursor", "error", err)
+				}
+				continue
+			}
+
+			if dryRun {
+				fmt.Printf("%d\t%s\t%s\t%s\n", audit.ID, audit.LoggedAt.Format(time.RFC3339), parsedData.Type, parsedData.ID)
+				result.Delivered++
+				continue
+			}
+
+			wg.Add(1)
+			deliverEvent(log, eventCtx, dbPool, auth, retryPolicy, routes, pool, *parsedData, func(err error) {
+				defer wg.Done()
+				if cursorErr := checkpoint.Done(audit.ID, audit.LoggedAt, err); cursorErr != nil {
+					log.Error("failed to save cursor", "error", cursorErr)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					log.Error("failed to replay event", "auditId", audit.ID, "error", err)
+					result.Failed++
+					return
+				}
+				result.Delivered++
+			})
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		result.LastId = audits[len(audits)-1].ID
+	}
+}
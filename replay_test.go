@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestParseTime(t *testing.T) {
+	is := is.New(t)
+
+	for value, expected := range map[string]time.Time{
+		"2026-10-01T12:30:15Z":      time.Date(2026, 10, 1, 12, 30, 15, 0, time.UTC),
+		"2026-10-01T12:30Z":         time.Date(2026, 10, 1, 12, 30, 0, 0, time.UTC),
+		"2026-10-01T12:30:00+02:00": time.Date(2026, 10, 1, 10, 30, 0, 0, time.UTC),
+		"2026-10-01":                time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		parsed, err := parseTime(value)
+		is.NoErr(err)
+		is.True(parsed.Equal(expected))
+	}
+
+	_, err := parseTime("yesterday")
+	is.True(err != nil)
+}
+
+func TestReplayFilter(t *testing.T) {
+	is := is.New(t)
+
+	filter, err := replayFilter("2026-10-01", "2026-10-02", 0, 0, []string{"submission.create", "entity.update.*"})
+	is.NoErr(err)
+	is.True(filter.Since.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)))
+	is.True(filter.Until.Equal(time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)))
+	is.Equal(filter.Actions, []string{"entity.update.version", "entity.update.resolve", "submission.create"})
+
+	// By audit id, for all actions
+	filter, err = replayFilter("", "", 10, 20, nil)
+	is.NoErr(err)
+	is.Equal(filter.SinceId, int64(10))
+	is.Equal(filter.UntilId, int64(20))
+	is.Equal(len(filter.Actions), 0)
+
+	for _, tc := range []struct {
+		since, until string
+		actions      []string
+	}{
+		{"", "", nil},                          // no start
+		{"2026-10-02", "2026-10-01", nil},      // until before since
+		{"2026-10-01", "", []string{"user.*"}}, // no supported actions
+		{"1 October", "", nil},                 // invalid time
+	} {
+		_, err := replayFilter(tc.since, tc.until, 0, 0, tc.actions)
+		is.True(err != nil)
+	}
+}
@@ -0,0 +1,224 @@
+package router
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+// Route sends matching events to one or more endpoints.
+//
+// Each filter matches if any of its values match, and an empty filter
+// matches all events. An event must match all filters for the route to match.
+type Route struct {
+	Name       string   // Optional name, for logging
+	Events     []string // Event types, with wildcards, e.g. "submission.*"
+	ProjectIds []int
+	XmlFormIds []string
+	Datasets   []string
+	Endpoints  []string // The endpoints to send matching events to, by name or url
+}
+
+// Matches returns true if the event matches all filters of the route
+func (route Route) Matches(event parser.ProcessedEvent) bool {
+	if len(route.Events) > 0 && !slices.ContainsFunc(route.Events, func(pattern string) bool {
+		return matchEventType(pattern, event.Type)
+	}) {
+		return false
+	}
+	if len(route.ProjectIds) > 0 && !slices.Contains(route.ProjectIds, event.ProjectId) {
+		return false
+	}
+	if len(route.XmlFormIds) > 0 && !slices.Contains(route.XmlFormIds, event.XmlFormId) {
+		return false
+	}
+	if len(route.Datasets) > 0 && !slices.Contains(route.Datasets, event.Dataset) {
+		return false
+	}
+	return true
+}
+
+// matchEventType matches an event type against a pattern, where * matches
+// any characters, e.g. "submission.*" matches "submission.update.version"
+func matchEventType(pattern, eventType string) bool {
+	matched, err := path.Match(pattern, eventType)
+	return err == nil && matched
+}
+
+// Router matches events against a routing table, which can be replaced
+// while events are being matched, e.g. when the config is reloaded
+type Router struct {
+	table atomic.Pointer[table]
+}
+
+type table struct {
+	routes      []Route
+	endpoints   map[string]webhook.Endpoint
+	retryPolicy *webhook.RetryPolicy // For url endpoints, nil for the caller's default
+}
+
+// New returns a Router for the routes. Routes reference the endpoints by
+// name, with any other reference used as the endpoint url.
+func New(routes []Route, endpoints ...webhook.Endpoint) *Router {
+	r := &Router{}
+	r.Replace(routes, endpoints...)
+	return r
+}
+
+// Replace atomically replaces the routes and endpoints. Each Match uses
+// either the previous or the new table, never a mix of both.
+func (r *Router) Replace(routes []Route, endpoints ...webhook.Endpoint) {
+	r.ReplaceWithRetryPolicy(routes, nil, endpoints...)
+}
+
+// ReplaceWithRetryPolicy replaces the routes and endpoints as Replace, along
+// with the retry policy of the endpoints referenced by url, e.g. the default
+// policy of a reloaded config. Named endpoints have their own policy.
+func (r *Router) ReplaceWithRetryPolicy(routes []Route, retryPolicy *webhook.RetryPolicy, endpoints ...webhook.Endpoint) {
+	byName := make(map[string]webhook.Endpoint, len(endpoints))
+	for _, endpoint := range endpoints {
+		byName[endpoint.Name] = endpoint
+	}
+	r.table.Store(&table{routes: routes, endpoints: byName, retryPolicy: retryPolicy})
+}
+
+// Routes returns the routing table
+func (r *Router) Routes() []Route {
+	return r.table.Load().routes
+}
+
+// URLs returns the urls of the endpoints, and of the routes to a url, e.g.
+// to close the sinks of urls that are no longer used
+func (r *Router) URLs() []string {
+	t := r.table.Load()
+	var urls []string
+	for _, endpoint := range t.endpoints {
+		urls = append(urls, endpoint.URL)
+	}
+	for _, route := range t.routes {
+		for _, ref := range route.Endpoints {
+			urls = append(urls, t.endpoint(ref).URL)
+		}
+	}
+	slices.Sort(urls)
+	return slices.Compact(urls)
+}
+
+// Match returns the endpoints of all routes matching the event, in route
+// order, without duplicates. Nil is returned if no routes match.
+func (r *Router) Match(event parser.ProcessedEvent) []webhook.Endpoint {
+	t := r.table.Load()
+
+	var refs []string
+	for _, route := range t.routes {
+		if !route.Matches(event) {
+			continue
+		}
+		for _, ref := range route.Endpoints {
+			if !slices.Contains(refs, ref) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	var endpoints []webhook.Endpoint
+	for _, ref := range refs {
+		endpoints = append(endpoints, t.endpoint(ref))
+	}
+	return endpoints
+}
+
+// endpoint returns the endpoint with the name, or an endpoint for the url
+func (t *table) endpoint(ref string) webhook.Endpoint {
+	if endpoint, ok := t.endpoints[ref]; ok {
+		return endpoint
+	}
+	return webhook.Endpoint{URL: ref, RetryPolicy: t.retryPolicy}
+}
+
+// routeKeys are the keys of the key=value pairs in a route
+var routeKeys = []string{"name", "event", "project", "form", "dataset", "url"}
+
+// ParseRoute parses a route from comma separated key=value pairs, with keys
+// event, project, form, dataset, url and name. Keys may be repeated, e.g.
+//
+//	event=submission.*,project=3,url=https://a.example.com,url=https://b.example.com
+//
+// A url may contain commas, e.g. url=https://a.example.com?fields=a,b, unless
+// followed by one of the keys and =.
+func ParseRoute(s string) (Route, error) {
+	var route Route
+
+	for _, pair := range splitRoute(s) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return route, fmt.Errorf("invalid route %q: expected key=value, got %q", s, pair)
+		}
+
+		switch strings.TrimSpace(key) {
+		case "name":
+			route.Name = value
+		case "event":
+			if _, err := path.Match(value, ""); err != nil {
+				return route, fmt.Errorf("invalid route %q: invalid event pattern %q", s, value)
+			}
+			route.Events = append(route.Events, value)
+		case "project":
+			projectId, err := strconv.Atoi(value)
+			if err != nil {
+				return route, fmt.Errorf("invalid route %q: invalid project id %q", s, value)
+			}
+			route.ProjectIds = append(route.ProjectIds, projectId)
+		case "form":
+			route.XmlFormIds = append(route.XmlFormIds, value)
+		case "dataset":
+			route.Datasets = append(route.Datasets, value)
+		case "url":
+			route.Endpoints = append(route.Endpoints, value)
+		default:
+			return route, fmt.Errorf("invalid route %q: unknown key %q", s, key)
+		}
+	}
+
+	if len(route.Endpoints) == 0 {
+		return route, fmt.Errorf("invalid route %q: at least one url is required", s)
+	}
+
+	return route, nil
+}
+
+// splitRoute splits a route into its key=value pairs at commas, except the
+// commas in a url that are not followed by a key
+func splitRoute(s string) []string {
+	var pairs []string
+	for _, part := range strings.Split(s, ",") {
+		if n := len(pairs); n > 0 && routeKey(pairs[n-1]) == "url" && routeKey(part) == "" && strings.TrimSpace(part) != "" {
+			pairs[n-1] += "," + part
+			continue
+		}
+		pairs = append(pairs, part)
+	}
+	return pairs
+}
+
+// routeKey returns the key of a key=value pair, or "" if it is not a key
+func routeKey(pair string) string {
+	key, _, ok := strings.Cut(pair, "=")
+	key = strings.TrimSpace(key)
+	if !ok || !slices.Contains(routeKeys, key) {
+		return ""
+	}
+	return key
+}
@@ -0,0 +1,171 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/webhook"
+)
+
+func TestRouteMatches(t *testing.T) {
+	is := is.New(t)
+
+	submission := parser.ProcessedEvent{
+		Type:      "submission.update.version",
+		ProjectId: 3,
+		XmlFormId: "buildings",
+	}
+	entity := parser.ProcessedEvent{
+		Type:      "entity.update.version",
+		ProjectId: 4,
+		Dataset:   "trees",
+	}
+
+	t.Run("Empty Route", func(t *testing.T) {
+		route := Route{Endpoints: []string{"https://example.com"}}
+		is.True(route.Matches(submission))
+		is.True(route.Matches(entity))
+	})
+
+	t.Run("Event Wildcard", func(t *testing.T) {
+		route := Route{Events: []string{"submission.*"}}
+		is.True(route.Matches(submission))
+		is.True(!route.Matches(entity))
+
+		route = Route{Events: []string{"*.update.version"}}
+		is.True(route.Matches(submission))
+		is.True(route.Matches(entity))
+
+		route = Route{Events: []string{"submission.create"}}
+		is.True(!route.Matches(submission))
+	})
+
+	t.Run("Any Value Matches", func(t *testing.T) {
+		route := Route{ProjectIds: []int{1, 3}}
+		is.True(route.Matches(submission))
+		is.True(!route.Matches(entity))
+
+		route = Route{Datasets: []string{"trees", "rivers"}}
+		is.True(!route.Matches(submission))
+		is.True(route.Matches(entity))
+	})
+
+	t.Run("All Filters Match", func(t *testing.T) {
+		route := Route{
+			Events:     []string{"submission.*"},
+			ProjectIds: []int{3},
+			XmlFormIds: []string{"buildings"},
+		}
+		is.True(route.Matches(submission))
+
+		route.XmlFormIds = []string{"roads"}
+		is.True(!route.Matches(submission))
+	})
+}
+
+func TestRouterMatch(t *testing.T) {
+	is := is.New(t)
+
+	retryPolicy := webhook.DefaultRetryPolicy()
+	r := New([]Route{
+		{Events: []string{"submission.*"}, ProjectIds: []int{1}, Endpoints: []string{"https://a.example.com"}},
+		{Events: []string{"submission.*"}, ProjectIds: []int{2}, Endpoints: []string{"project-2"}},
+		{Events: []string{"submission.create"}, Endpoints: []string{"https://c.example.com", "https://a.example.com"}},
+	}, webhook.Endpoint{
+		Name:        "project-2",
+		URL:         "https://b.example.com",
+		RetryPolicy: &retryPolicy,
+	})
+
+	urls := func(endpoints []webhook.Endpoint) []string {
+		var urls []string
+		for _, endpoint := range endpoints {
+			urls = append(urls, endpoint.URL)
+		}
+		return urls
+	}
+
+	// Fan out to all matching routes, without duplicates
+	endpoints := r.Match(parser.ProcessedEvent{Type: "submission.create", ProjectId: 1})
+	is.Equal(urls(endpoints), []string{"https://a.example.com", "https://c.example.com"})
+	is.Equal(endpoints[0].Name, "") // not a named endpoint
+
+	// Named endpoints are resolved
+	endpoints = r.Match(parser.ProcessedEvent{Type: "submission.delete", ProjectId: 2})
+	is.Equal(urls(endpoints), []string{"https://b.example.com"})
+	is.Equal(endpoints[0].Name, "project-2")
+	is.Equal(endpoints[0].RetryPolicy, &retryPolicy)
+
+	endpoints = r.Match(parser.ProcessedEvent{Type: "entity.create", ProjectId: 1})
+	is.Equal(endpoints, nil)
+}
+
+func TestRouterReplace(t *testing.T) {
+	is := is.New(t)
+
+	r := New([]Route{{Endpoints: []string{"a"}}}, webhook.Endpoint{Name: "a", URL: "https://a.example.com"})
+	event := parser.ProcessedEvent{Type: "submission.create"}
+	is.Equal(r.Match(event)[0].URL, "https://a.example.com")
+
+	// Credentials and urls of named endpoints are replaced with the routes
+	r.Replace([]Route{{Endpoints: []string{"a"}}}, webhook.Endpoint{
+		Name: "a",
+		URL:  "https://a2.example.com",
+		Auth: &webhook.Auth{ApiKey: "new"},
+	})
+	endpoints := r.Match(event)
+	is.Equal(endpoints[0].URL, "https://a2.example.com")
+	is.Equal(endpoints[0].Auth.ApiKey, "new")
+
+	is.Equal(r.URLs(), []string{"https://a2.example.com"})
+
+	// Url endpoints have the retry policy of the table, if any
+	policy := webhook.RetryPolicy{MaxAttempts: 2}
+	r.ReplaceWithRetryPolicy([]Route{{Endpoints: []string{"a", "https://b.example.com"}}}, &policy, webhook.Endpoint{Name: "a", URL: "https://a.example.com"})
+	endpoints = r.Match(event)
+	is.Equal(endpoints[0].RetryPolicy, nil)
+	is.Equal(endpoints[1].RetryPolicy, &policy)
+	is.Equal(r.URLs(), []string{"https://a.example.com", "https://b.example.com"})
+
+	r.Replace(nil)
+	is.Equal(r.Match(event), nil)
+	is.Equal(len(r.Routes()), 0)
+}
+
+func TestParseRoute(t *testing.T) {
+	is := is.New(t)
+
+	route, err := ParseRoute("name=project 3, event=submission.*, event=form.*, project=3, form=buildings, url=https://a.example.com, url=https://b.example.com")
+	is.NoErr(err)
+	is.Equal(route.Name, "project 3")
+	is.Equal(route.Events, []string{"submission.*", "form.*"})
+	is.Equal(route.ProjectIds, []int{3})
+	is.Equal(route.XmlFormIds, []string{"buildings"})
+	is.Equal(route.Endpoints, []string{"https://a.example.com", "https://b.example.com"})
+
+	route, err = ParseRoute("dataset=trees,url=https://a.example.com")
+	is.NoErr(err)
+	is.Equal(route.Datasets, []string{"trees"})
+
+	// Commas in a url are kept, unless followed by a key
+	route, err = ParseRoute("url=https://a.example.com?fields=a,b&x=1,2,project=3,url=https://b.example.com?fields=c,d")
+	is.NoErr(err)
+	is.Equal(route.Endpoints, []string{"https://a.example.com?fields=a,b&x=1,2", "https://b.example.com?fields=c,d"})
+	is.Equal(route.ProjectIds, []int{3})
+
+	invalid := []string{
+		"",
+		"event=submission.*",                    // no url
+		"project=abc,url=https://a.example.com", // invalid project id
+		"event=[,url=https://a.example.com",     // invalid pattern
+		"colour=red,url=https://a.example.com",  // unknown key
+		"event,url=https://a.example.com",       // missing value
+		"event=a,b,url=https://a.example.com",   // commas are only kept in urls
+	}
+	for _, s := range invalid {
+		_, err := ParseRoute(s)
+		is.True(err != nil)
+	}
+}
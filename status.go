@@ -0,0 +1,153 @@
+// The status server, for Prometheus metrics and health checks
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/hotosm/central-webhook/db"
+	"github.com/hotosm/central-webhook/metrics"
+)
+
+// The longest time since the last successful ping of the listener
+// connection before the service is not ready, a few missed pings
+const maxPingAge = 90 * time.Second
+
+// The time allowed for the readiness checks, per request
+const readyTimeout = 5 * time.Second
+
+// Readiness checks whether the service is ready to deliver events. It is
+// not ready until SetupWebhook has started listening.
+type Readiness struct {
+	mu       sync.Mutex
+	dbPool   *pgxpool.Pool
+	notifier db.Notifier
+	sub      db.Subscription
+}
+
+// set marks the service as started, with what to check
+func (r *Readiness) set(dbPool *pgxpool.Pool, notifier db.Notifier, sub db.Subscription) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dbPool = dbPool
+	r.notifier = notifier
+	r.sub = sub
+}
+
+// Check runs the readiness checks, returning "ok" or the reason for each,
+// and whether all passed
+func (r *Readiness) Check(ctx context.Context) (map[string]string, bool) {
+	checks := map[string]string{
+		"database": "not started",
+		"listen":   "not started",
+		"ping":     "not started",
+		"trigger":  "not started",
+	}
+	if r == nil {
+		return checks, false
+	}
+	r.mu.Lock()
+	dbPool, notifier, sub := r.dbPool, r.notifier, r.sub
+	r.mu.Unlock()
+	if dbPool == nil {
+		return checks, false
+	}
+
+	ready := true
+	fail := func(name string, reason string) {
+		checks[name] = reason
+		ready = false
+	}
+
+	if err := dbPool.Ping(ctx); err != nil {
+		fail("database", err.Error())
+	} else {
+		checks["database"] = "ok"
+	}
+
+	select {
+	case <-sub.EstablishedC():
+		checks["listen"] = "ok"
+	default:
+		fail("listen", "not listening to odk-events")
+	}
+
+	if lastPing := notifier.LastPing(); lastPing.IsZero() {
+		fail("ping", "not connected")
+	} else if age := time.Since(lastPing); age > maxPingAge {
+		fail("ping", "last ping "+age.Round(time.Second).String()+" ago")
+	} else {
+		checks["ping"] = "ok"
+	}
+
+	if exists, err := db.TriggerExists(ctx, dbPool, "audits"); err != nil {
+		fail("trigger", err.Error())
+	} else if !exists {
+		fail("trigger", "trigger missing on audits table")
+	} else {
+		checks["trigger"] = "ok"
+	}
+
+	return checks, ready
+}
+
+// statusHandler serves the status endpoints
+func statusHandler(readiness *Readiness) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	// The process is alive
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+
+	// The service is ready to deliver events
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		checks, ready := readiness.Check(ctx)
+
+		status, code := "ok", http.StatusOK
+		if !ready {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": checks})
+	})
+	return mux
+}
+
+// serveStatus serves the status endpoints on the address, until the
+// context is done
+func serveStatus(log *slog.Logger, ctx context.Context, addr string, readiness *Readiness) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           statusHandler(readiness),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("serving status endpoints", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
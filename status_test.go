@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestStatusMetrics(t *testing.T) {
+	is := is.New(t)
+
+	server := httptest.NewServer(statusHandler(nil))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	is.NoErr(err)
+	defer resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	body, err := io.ReadAll(resp.Body)
+	is.NoErr(err)
+	is.True(strings.Contains(string(body), "centralwebhook_parse_failures_total"))
+}
+
+func TestStatusHealth(t *testing.T) {
+	is := is.New(t)
+
+	server := httptest.NewServer(statusHandler(&Readiness{}))
+	defer server.Close()
+
+	// Alive before connecting to the database
+	resp, err := http.Get(server.URL + "/healthz")
+	is.NoErr(err)
+	resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusOK)
+
+	// But not ready until listening
+	resp, err = http.Get(server.URL + "/readyz")
+	is.NoErr(err)
+	defer resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusServiceUnavailable)
+
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	is.NoErr(json.NewDecoder(resp.Body).Decode(&body))
+	is.Equal(body.Status, "unavailable")
+	is.Equal(body.Checks["listen"], "not started")
+}
@@ -0,0 +1,110 @@
+// OpenTelemetry tracing of events, from the database notification to delivery
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The instrumentation name, and the service name if not set by
+// OTEL_SERVICE_NAME
+const (
+	name        = "github.com/hotosm/central-webhook"
+	serviceName = "central-webhook"
+)
+
+// Exporter is where spans are exported to
+type Exporter string
+
+const (
+	// Do not record spans (the default)
+	ExporterNone Exporter = "none"
+
+	// Write spans to stdout as JSON, for local testing
+	ExporterStdout Exporter = "stdout"
+
+	// Send spans to an OpenTelemetry collector with OTLP over HTTP,
+	// configured with the standard OTEL_EXPORTER_OTLP_* variables
+	ExporterOtlp Exporter = "otlp"
+)
+
+// ParseExporter returns the exporter with the name, or an error if unknown.
+// An empty name is ExporterNone.
+func ParseExporter(value string) (Exporter, error) {
+	switch exporter := Exporter(strings.ToLower(value)); exporter {
+	case "":
+		return ExporterNone, nil
+	case ExporterNone, ExporterStdout, ExporterOtlp:
+		return exporter, nil
+	default:
+		return "", fmt.Errorf("unknown tracing exporter %q, must be none, stdout or otlp", value)
+	}
+}
+
+// Tracer returns the tracer for the spans of the service
+func Tracer() trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// Setup sets the global tracer provider to export spans with the exporter,
+// and W3C trace context propagation. The returned function flushes any
+// spans not exported yet, and should be called before exiting.
+func Setup(ctx context.Context, exporter Exporter) (func(context.Context) error, error) {
+	return setup(ctx, exporter, os.Stdout)
+}
+
+// setup is Setup, writing spans for ExporterStdout to the writer
+func setup(ctx context.Context, exporter Exporter, stdout io.Writer) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterNone, "":
+		// The global provider is a no-op until set
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case ExporterOtlp:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(
+		resource.NewSchemaless(attribute.String("service.name", serviceName)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Inject adds the traceparent header of the span in the context, if any
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
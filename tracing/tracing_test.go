@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParseExporter(t *testing.T) {
+	is := is.New(t)
+
+	exporter, err := ParseExporter("")
+	is.NoErr(err)
+	is.Equal(exporter, ExporterNone)
+
+	exporter, err = ParseExporter("STDOUT")
+	is.NoErr(err)
+	is.Equal(exporter, ExporterStdout)
+
+	_, err = ParseExporter("jaeger")
+	is.True(err != nil)
+}
+
+func TestSetupStdout(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	shutdown, err := setup(ctx, ExporterStdout, &out)
+	is.NoErr(err)
+
+	ctx, span := Tracer().Start(ctx, "test span")
+	header := http.Header{}
+	Inject(ctx, header)
+	span.End()
+	is.NoErr(shutdown(ctx))
+
+	// The traceparent references the span
+	traceparent := header.Get("traceparent")
+	is.True(strings.Contains(traceparent, span.SpanContext().TraceID().String()))
+	is.True(strings.Contains(traceparent, span.SpanContext().SpanID().String()))
+
+	is.True(strings.Contains(out.String(), `"Name":"test span"`))
+	is.True(strings.Contains(out.String(), `"service.name"`))
+}
@@ -1 +1,183 @@
 package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Auth is the authentication sent with each request to an endpoint.
+// Set at most one of ApiKey, BearerToken, Basic or OAuth2. Requests can also
+// be signed, with or without one of these.
+type Auth struct {
+	ApiKey      string     // Sent as the X-API-Key header
+	BearerToken string     // Sent as the Authorization: Bearer header
+	Basic       *BasicAuth // HTTP basic authentication
+	OAuth2      *OAuth2    // Bearer tokens from the client credentials grant
+	// Secrets to sign requests with, adding a signature for each, so a
+	// secret can be rotated by adding the new secret before removing the old
+	SigningSecrets []string
+}
+
+// BasicAuth is a username and password for HTTP basic authentication
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// apply adds the authentication and signature headers to the request
+func (auth *Auth) apply(req *http.Request, msgId string, payload []byte) error {
+	if auth == nil {
+		return nil
+	}
+	if auth.ApiKey != "" {
+		req.Header.Set("X-API-Key", auth.ApiKey)
+	}
+	if auth.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+auth.BearerToken)
+	}
+	if auth.Basic != nil {
+		req.SetBasicAuth(auth.Basic.Username, auth.Basic.Password)
+	}
+	if auth.OAuth2 != nil {
+		token, err := auth.OAuth2.Token(req.Context())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if len(auth.SigningSecrets) > 0 {
+		timestamp := time.Now()
+		signature, err := Sign(msgId, timestamp, payload, auth.SigningSecrets...)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(HeaderWebhookId, msgId)
+		req.Header.Set(HeaderWebhookTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+		req.Header.Set(HeaderWebhookSignature, signature)
+	}
+	return nil
+}
+
+// The signature headers, following the Standard Webhooks specification
+// https://github.com/standard-webhooks/standard-webhooks/blob/main/spec/standard-webhooks.md
+const (
+	HeaderWebhookId        = "webhook-id"        // Unique per event, the same for retries
+	HeaderWebhookTimestamp = "webhook-timestamp" // Unix timestamp in seconds
+	HeaderWebhookSignature = "webhook-signature" // Space separated signatures, e.g. v1,{base64}
+)
+
+// SignatureTolerance is the maximum difference between the signature
+// timestamp and the current time accepted by VerifySignature, to prevent
+// replay attacks
+const SignatureTolerance = 5 * time.Minute
+
+// The prefix of base64 encoded secrets, as generated by Standard Webhooks
+// libraries. Secrets without this prefix are used as is.
+const secretPrefix = "whsec_"
+
+var (
+	ErrMissingSignature = errors.New("missing webhook signature headers")
+	ErrInvalidTimestamp = errors.New("webhook timestamp is invalid or outside the tolerance")
+	ErrInvalidSignature = errors.New("no matching webhook signature")
+)
+
+// Sign returns the webhook-signature header value for the payload, with a
+// signature for each secret. The signed content is "{msgId}.{timestamp}.{payload}".
+func Sign(msgId string, timestamp time.Time, payload []byte, secrets ...string) (string, error) {
+	signatures := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		key, err := secretKey(secret)
+		if err != nil {
+			return "", err
+		}
+		signatures = append(signatures, "v1,"+sign(key, msgId, timestamp.Unix(), payload))
+	}
+	return strings.Join(signatures, " "), nil
+}
+
+// VerifySignature checks the signature headers of a webhook request against
+// the raw request body, for consumers of the webhook. The request is valid
+// if any signature matches any of the secrets, and the timestamp is within
+// SignatureTolerance of the current time.
+//
+//	body, _ := io.ReadAll(r.Body)
+//	if err := webhook.VerifySignature(r.Header, body, secret); err != nil {
+//		w.WriteHeader(http.StatusUnauthorized)
+//		return
+//	}
+func VerifySignature(header http.Header, payload []byte, secrets ...string) error {
+	return verifySignature(header, payload, time.Now(), secrets...)
+}
+
+func verifySignature(header http.Header, payload []byte, now time.Time, secrets ...string) error {
+	msgId := header.Get(HeaderWebhookId)
+	timestampHeader := header.Get(HeaderWebhookTimestamp)
+	signatureHeader := header.Get(HeaderWebhookSignature)
+	if msgId == "" || timestampHeader == "" || signatureHeader == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	if diff := now.Sub(time.Unix(timestamp, 0)); diff > SignatureTolerance || diff < -SignatureTolerance {
+		return ErrInvalidTimestamp
+	}
+
+	for _, secret := range secrets {
+		key, err := secretKey(secret)
+		if err != nil {
+			return err
+		}
+		expected := sign(key, msgId, timestamp, payload)
+
+		for _, signature := range strings.Fields(signatureHeader) {
+			version, value, ok := strings.Cut(signature, ",")
+			if !ok || version != "v1" {
+				continue
+			}
+			if hmac.Equal([]byte(value), []byte(expected)) {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// ValidateSigningSecret returns an error if the secret cannot be used to sign
+func ValidateSigningSecret(secret string) error {
+	_, err := secretKey(secret)
+	return err
+}
+
+// secretKey returns the HMAC key for the secret
+func secretKey(secret string) ([]byte, error) {
+	if secret == "" {
+		return nil, errors.New("signing secret is empty")
+	}
+	if encoded, ok := strings.CutPrefix(secret, secretPrefix); ok {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s signing secret: %w", secretPrefix, err)
+		}
+		return key, nil
+	}
+	return []byte(secret), nil
+}
+
+// sign returns the base64 HMAC-SHA256 signature of the message
+func sign(key []byte, msgId string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%d.", msgId, timestamp)
+	mac.Write(payload)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/parser"
+)
+
+func TestSign(t *testing.T) {
+	is := is.New(t)
+
+	// Example from the Standard Webhooks specification
+	signature, err := Sign(
+		"msg_p5jXN8AQM9LWM0D4loKWxJek",
+		time.Unix(1614265330, 0),
+		[]byte(`{"test": 2432232314}`),
+		"whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw",
+	)
+	is.NoErr(err)
+	is.Equal(signature, "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=")
+
+	// A signature for each secret
+	signature, err = Sign("msg_1", time.Unix(1614265330, 0), []byte(`{}`), "secret-1", "secret-2")
+	is.NoErr(err)
+	is.Equal(len(signature), 2*len("v1,")+2*44+1)
+
+	_, err = Sign("msg_1", time.Now(), []byte(`{}`), "whsec_not base64!")
+	is.True(err != nil)
+	_, err = Sign("msg_1", time.Now(), []byte(`{}`), "")
+	is.True(err != nil)
+}
+
+func TestVerifySignature(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	payload := []byte(`{"type":"submission.create","id":"abc"}`)
+	signedHeader := func(timestamp time.Time, secrets ...string) http.Header {
+		signature, err := Sign("msg_1", timestamp, payload, secrets...)
+		is.NoErr(err)
+		header := http.Header{}
+		header.Set(HeaderWebhookId, "msg_1")
+		header.Set(HeaderWebhookTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+		header.Set(HeaderWebhookSignature, signature)
+		return header
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		is.NoErr(verifySignature(signedHeader(now, "secret"), payload, now, "secret"))
+	})
+
+	t.Run("Secret Rotation", func(t *testing.T) {
+		// Signed with the old and new secret, while consumers update
+		header := signedHeader(now, "old", "new")
+		is.NoErr(verifySignature(header, payload, now, "old"))
+		is.NoErr(verifySignature(header, payload, now, "new"))
+		// Consumers may also accept multiple secrets
+		is.NoErr(verifySignature(signedHeader(now, "new"), payload, now, "old", "new"))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		err := verifySignature(signedHeader(now, "secret"), payload, now, "other")
+		is.True(errors.Is(err, ErrInvalidSignature))
+
+		err = verifySignature(signedHeader(now, "secret"), []byte(`{"tampered":true}`), now, "secret")
+		is.True(errors.Is(err, ErrInvalidSignature))
+
+		err = verifySignature(signedHeader(now.Add(-10*time.Minute), "secret"), payload, now, "secret")
+		is.True(errors.Is(err, ErrInvalidTimestamp))
+
+		err = verifySignature(http.Header{}, payload, now, "secret")
+		is.True(errors.Is(err, ErrMissingSignature))
+	})
+}
+
+func TestSendSigned(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	var verifyErr error
+	var msgId string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = VerifySignature(r.Header, body, "secret")
+		msgId = r.Header.Get(HeaderWebhookId)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	endpoint := Endpoint{
+		URL:  server.URL,
+		Auth: &Auth{ApiKey: "key", SigningSecrets: []string{"secret"}},
+	}
+	event := parser.ProcessedEvent{ID: "abc", Type: "submission.create", OutboxId: 42}
+
+	_, err := Send(log, context.Background(), endpoint, event)
+	is.NoErr(err)
+	is.NoErr(verifyErr)
+	is.Equal(msgId, "msg_42") // the same if the event is delivered again
+
+	// Events not from the outbox get a random id
+	event.OutboxId = 0
+	_, err = Send(log, context.Background(), endpoint, event)
+	is.NoErr(err)
+	is.NoErr(verifyErr)
+	is.True(msgId != "msg_42" && msgId != "")
+
+	// Events from the audit log use the event id
+	event.EventId = "evt_7"
+	_, err = Send(log, context.Background(), endpoint, event)
+	is.NoErr(err)
+	is.NoErr(verifyErr)
+	is.Equal(msgId, "evt_7")
+}
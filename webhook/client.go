@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"cmp"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The client defaults, for options that are not set
+const (
+	defaultTimeout             = 10 * time.Second // for each request, including reading the response
+	defaultConnectTimeout      = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 16 // more than the http.DefaultTransport 2, for bursts of events
+)
+
+// defaultClient is used for endpoints without their own client
+var defaultClient = NewClient(ClientOptions{})
+
+// ClientOptions configures the HTTP client for an endpoint. Zero values use
+// the defaults.
+type ClientOptions struct {
+	TLSConfig             *tls.Config   // nil for the default TLS config
+	Timeout               time.Duration // The total time for each request, including reading the response
+	ConnectTimeout        time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // 0 to only limit by Timeout
+	IdleConnTimeout       time.Duration // How long idle keep-alive connections are kept open
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int  // 0 for no limit
+	DisableHTTP2          bool // Only use HTTP/1.1
+	// The proxy for each request, nil to use the HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY environment variables, or http.ProxyURL(nil) for no proxy
+	Proxy func(*http.Request) (*url.URL, error)
+}
+
+// NewClient returns an HTTP client with the options. Create one client per
+// endpoint and reuse it, so connections are reused between requests.
+func NewClient(opts ClientOptions) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   cmp.Or(opts.ConnectTimeout, defaultConnectTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 opts.Proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       opts.TLSConfig,
+		ForceAttemptHTTP2:     !opts.DisableHTTP2,
+		TLSHandshakeTimeout:   cmp.Or(opts.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		IdleConnTimeout:       cmp.Or(opts.IdleConnTimeout, defaultIdleConnTimeout),
+		MaxIdleConns:          cmp.Or(opts.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   cmp.Or(opts.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if transport.Proxy == nil {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	if opts.DisableHTTP2 {
+		// A non-nil empty map disables HTTP/2 negotiation
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return &http.Client{
+		Timeout:   cmp.Or(opts.Timeout, defaultTimeout),
+		Transport: transport,
+	}
+}
+
+// client returns the endpoint client, or the default client if not set
+func (endpoint Endpoint) client() *http.Client {
+	if endpoint.Client != nil {
+		return endpoint.Client
+	}
+	return defaultClient
+}
@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/parser"
+)
+
+func TestClientReusesConnections(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A response longer than is kept, which must be read to reuse the connection
+		w.Write([]byte(strings.Repeat("a", maxResponseBody+1)))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	endpoint := Endpoint{URL: server.URL, Client: NewClient(ClientOptions{})}
+	event := parser.ProcessedEvent{ID: "abc", Type: "submission.create"}
+	for range 10 {
+		result, err := Send(log, context.Background(), endpoint, event)
+		is.NoErr(err)
+		is.Equal(len(result.ResponseBody), maxResponseBody)
+	}
+	is.Equal(connections.Load(), int32(1))
+}
+
+func TestClientTimeout(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body) // so the server detects the client closing the connection
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	endpoint := Endpoint{
+		URL:    server.URL,
+		Client: NewClient(ClientOptions{ResponseHeaderTimeout: 50 * time.Millisecond}),
+	}
+	start := time.Now()
+	_, err := Send(log, context.Background(), endpoint, parser.ProcessedEvent{ID: "abc"})
+	is.True(err != nil)
+	is.True(time.Since(start) < time.Second)
+}
+
+func TestClientProxy(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	// The proxy receives the request with the full url of the endpoint
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+	proxyUrl, err := url.Parse(proxy.URL)
+	is.NoErr(err)
+
+	endpoint := Endpoint{
+		URL:    "http://webhook.invalid/events",
+		Client: NewClient(ClientOptions{Proxy: http.ProxyURL(proxyUrl)}),
+	}
+	_, err = Send(log, context.Background(), endpoint, parser.ProcessedEvent{ID: "abc"})
+	is.NoErr(err)
+	is.Equal(proxied, "http://webhook.invalid/events")
+}
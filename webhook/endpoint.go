@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Endpoint is a webhook API that events are sent to, with its own headers,
+// authentication and retry policy, or another sink, see Sink
+type Endpoint struct {
+	Name        string            // Optional name, for routing and logging
+	URL         string            // The url to POST events to, or of another sink
+	Headers     map[string]string // Extra headers sent with each request
+	Auth        *Auth             // nil to send no authentication
+	RetryPolicy *RetryPolicy      // nil to only attempt each request once
+	Client      *http.Client      // nil for the default client, see NewClient
+	Sink        Sink              // nil for the sink of the url scheme
+}
+
+// label returns the endpoint name, or the url without credentials or query,
+// for metrics
+func (endpoint Endpoint) label() string {
+	if endpoint.Name != "" {
+		return endpoint.Name
+	}
+	u, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return "invalid"
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
+
+// RedactURL returns the url with any password replaced, so it can be logged
+// or stored, e.g. for broker urls with credentials
+func RedactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "invalid"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Tokens are refreshed once this proportion of their lifetime has passed, so
+// a token does not expire while a request is being sent, and a failed
+// refresh can be tried again before it does
+const tokenRefreshAfter = 0.75
+
+// The time a token request may take, shared by all requests waiting for it
+const tokenRequestTimeout = 30 * time.Second
+
+// OAuth2 gets bearer tokens with the OAuth2 client credentials grant.
+// Tokens are cached until most of their lifetime has passed, so use the same
+// OAuth2 for each request to an endpoint.
+type OAuth2 struct {
+	TokenURL     string
+	ClientId     string
+	ClientSecret string
+	Scopes       []string
+	Params       map[string]string // Extra token request parameters, e.g. audience
+	Client       *http.Client      // nil for the default client
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time // zero if the token does not expire
+	expiry    time.Time
+	requests  singleflight.Group // one token request at a time
+}
+
+// tokenResponse is the successful token response, RFC 6749 section 5.1
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// tokenError is the error token response, RFC 6749 section 5.2
+type tokenError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Token returns the cached access token, or requests a new token if there
+// is none or it is due to be refreshed. Concurrent callers share one token
+// request, without blocking callers while the cached token is still fresh.
+func (o *OAuth2) Token(ctx context.Context) (string, error) {
+	o.mu.Lock()
+	token, refreshAt, expiry := o.token, o.refreshAt, o.expiry
+	o.mu.Unlock()
+
+	now := time.Now()
+	if token != "" && (refreshAt.IsZero() || now.Before(refreshAt)) {
+		return token, nil
+	}
+
+	newToken, err, _ := o.requests.Do("token", func() (any, error) {
+		// Not cancelled with the first caller, as others may be waiting
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenRequestTimeout)
+		defer cancel()
+		return o.refresh(ctx)
+	})
+	if err != nil {
+		// The current token can still be used until it expires
+		if token != "" && now.Before(expiry) {
+			return token, nil
+		}
+		return "", err
+	}
+	return newToken.(string), nil
+}
+
+// refresh requests a new token, and caches it
+func (o *OAuth2) refresh(ctx context.Context) (string, error) {
+	issued := time.Now()
+	token, expiresIn, err := o.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.token = token
+	o.refreshAt, o.expiry = time.Time{}, time.Time{}
+	if expiresIn > 0 {
+		o.refreshAt = issued.Add(time.Duration(float64(expiresIn) * tokenRefreshAfter))
+		o.expiry = issued.Add(expiresIn)
+	}
+	return token, nil
+}
+
+// invalidate removes the token from the cache, unless it has already been
+// replaced by a newer token
+func (o *OAuth2) invalidate(token string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.token == token {
+		o.token = ""
+	}
+}
+
+// requestToken requests a new access token from the token endpoint
+func (o *OAuth2) requestToken(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	for key, value := range o.Params {
+		form.Set(key, value)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// The client credentials are form encoded, RFC 6749 section 2.3.1
+	req.SetBasicAuth(url.QueryEscape(o.ClientId), url.QueryEscape(o.ClientSecret))
+
+	client := o.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to request OAuth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read OAuth2 token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var tokenErr tokenError
+		if json.Unmarshal(body, &tokenErr) == nil && tokenErr.Error != "" {
+			return "", 0, fmt.Errorf(
+				"OAuth2 token request failed with status code %d: %s %s",
+				resp.StatusCode, tokenErr.Error, tokenErr.ErrorDescription,
+			)
+		}
+		return "", 0, fmt.Errorf("OAuth2 token request failed with status code %d", resp.StatusCode)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", 0, fmt.Errorf("failed to parse OAuth2 token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("OAuth2 token response has no access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported OAuth2 token type %q", token.TokenType)
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
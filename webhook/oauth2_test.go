@@ -0,0 +1,195 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/parser"
+)
+
+// newTokenServer returns a token endpoint issuing token-1, token-2, ...
+// that expire after expiresIn seconds
+func newTokenServer(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	is := is.New(t)
+	var issued atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		is.NoErr(r.ParseForm())
+		clientId, clientSecret, ok := r.BasicAuth()
+		if !ok || clientId != "client" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client", "error_description": "bad credentials"}`)
+			return
+		}
+		is.Equal(r.PostForm.Get("grant_type"), "client_credentials")
+		is.Equal(r.PostForm.Get("scope"), "read write")
+		is.Equal(r.PostForm.Get("audience"), "https://api.example.com")
+
+		token := fmt.Sprintf("token-%d", issued.Add(1))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": token,
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func newOAuth2(tokenUrl string) *OAuth2 {
+	return &OAuth2{
+		TokenURL:     tokenUrl,
+		ClientId:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+		Params:       map[string]string{"audience": "https://api.example.com"},
+	}
+}
+
+func TestOAuth2Token(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	t.Run("Cached", func(t *testing.T) {
+		server, issued := newTokenServer(t, 3600)
+		oauth := newOAuth2(server.URL)
+
+		token, err := oauth.Token(ctx)
+		is.NoErr(err)
+		is.Equal(token, "token-1")
+		token, err = oauth.Token(ctx)
+		is.NoErr(err)
+		is.Equal(token, "token-1")
+		is.Equal(issued.Load(), int32(1))
+
+		// A newer token is not invalidated
+		oauth.invalidate("token-0")
+		token, _ = oauth.Token(ctx)
+		is.Equal(token, "token-1")
+
+		oauth.invalidate("token-1")
+		token, _ = oauth.Token(ctx)
+		is.Equal(token, "token-2")
+	})
+
+	t.Run("Refreshed Before Expiry", func(t *testing.T) {
+		server, issued := newTokenServer(t, 1)
+		oauth := newOAuth2(server.URL)
+
+		// Short lived tokens are still cached
+		token, err := oauth.Token(ctx)
+		is.NoErr(err)
+		is.Equal(token, "token-1")
+		token, err = oauth.Token(ctx)
+		is.NoErr(err)
+		is.Equal(token, "token-1")
+
+		// Refreshed once most of the lifetime has passed
+		time.Sleep(800 * time.Millisecond)
+		token, err = oauth.Token(ctx)
+		is.NoErr(err)
+		is.Equal(token, "token-2")
+		is.Equal(issued.Load(), int32(2))
+
+		// The current token is used if a refresh fails, until it expires
+		time.Sleep(800 * time.Millisecond)
+		oauth.ClientSecret = "wrong"
+		token, err = oauth.Token(ctx)
+		is.NoErr(err)
+		is.Equal(token, "token-2")
+		time.Sleep(300 * time.Millisecond)
+		_, err = oauth.Token(ctx)
+		is.True(err != nil)
+	})
+
+	t.Run("One Request At A Time", func(t *testing.T) {
+		var issued atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 3600}`, issued.Add(1))
+		}))
+		defer server.Close()
+		oauth := newOAuth2(server.URL)
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := oauth.Token(ctx)
+				is.NoErr(err)
+				is.Equal(token, "token-1")
+			}()
+		}
+		wg.Wait()
+		is.Equal(issued.Load(), int32(1))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		server, _ := newTokenServer(t, 3600)
+		oauth := newOAuth2(server.URL)
+		oauth.ClientSecret = "wrong"
+
+		_, err := oauth.Token(ctx)
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), "invalid_client bad credentials"))
+	})
+}
+
+func TestSendOAuth2(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	tokenServer, issued := newTokenServer(t, 3600)
+
+	// The api rejects token-1, as if it was revoked
+	var requests atomic.Int32
+	var rejectAll atomic.Bool
+	var receivedTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		receivedTokens = append(receivedTokens, token)
+		if token == "token-1" || rejectAll.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	endpoint := Endpoint{URL: server.URL, Auth: &Auth{OAuth2: newOAuth2(tokenServer.URL)}}
+	event := parser.ProcessedEvent{ID: "abc", Type: "submission.create"}
+
+	// Retried once with a new token, without using a retry attempt
+	result, err := Send(log, context.Background(), endpoint, event)
+	is.NoErr(err)
+	is.Equal(result.Attempts, 1)
+	is.Equal(receivedTokens, []string{"token-1", "token-2"})
+
+	// The new token is reused
+	_, err = Send(log, context.Background(), endpoint, event)
+	is.NoErr(err)
+	is.Equal(requests.Load(), int32(3))
+	is.Equal(issued.Load(), int32(2))
+
+	// Only retried once if the new token is also rejected
+	endpoint.Auth.OAuth2 = newOAuth2(tokenServer.URL)
+	rejectAll.Store(true)
+	result, err = Send(log, context.Background(), endpoint, event)
+	is.True(err != nil)
+	is.Equal(result.StatusCode, http.StatusUnauthorized)
+	is.Equal(requests.Load(), int32(5))
+}
@@ -3,67 +3,319 @@ package webhook
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hotosm/central-webhook/metrics"
 	"github.com/hotosm/central-webhook/parser"
+	"github.com/hotosm/central-webhook/tracing"
 )
 
+// DeliveryResult describes the outcome of sending an event to a webhook
+type DeliveryResult struct {
+	StatusCode   int    // The last response status code, 0 if no response was received
+	ResponseBody string // The last response body
+	Attempts     int    // The number of requests made, including retries
+}
+
 // SendRequest parses the request content JSON from the PostgreSQL notification
 // and sends the JSON payload to an external API endpoint.
+//
+// Failed requests are retried according to the retryPolicy, or attempted
+// only once if no policy is provided. An error is returned if the endpoint
+// did not respond with a 2xx status code after all attempts.
 func SendRequest(
 	log *slog.Logger,
 	ctx context.Context,
 	apiEndpoint string,
 	eventJson parser.ProcessedEvent,
 	apiKey *string,
-) {
+	retryPolicy *RetryPolicy, // use a pointer so it's possible to pass 'nil'
+) (*DeliveryResult, error) {
+	endpoint := Endpoint{URL: apiEndpoint, RetryPolicy: retryPolicy}
+	if apiKey != nil {
+		endpoint.Auth = &Auth{ApiKey: *apiKey}
+	}
+	return Send(log, ctx, endpoint, eventJson)
+}
+
+// Send sends the event JSON payload to the endpoint, with the endpoint
+// headers and authentication, retrying according to the endpoint retry policy.
+//
+// Each request has the W3C traceparent header of the span in the context.
+func Send(
+	log *slog.Logger,
+	ctx context.Context,
+	endpoint Endpoint,
+	eventJson parser.ProcessedEvent,
+) (*DeliveryResult, error) {
+	label := endpoint.label()
+	ctx, span := tracing.Tracer().Start(ctx, "deliver event", trace.WithAttributes(
+		attribute.String("webhook.endpoint", label),
+		attribute.String("central.event.type", eventJson.Type),
+		attribute.Int64("central.audit.id", eventJson.AuditId),
+	))
+	defer span.End()
+
+	start := time.Now()
+	result, err := send(log, ctx, endpoint, eventJson)
+
+	span.SetAttributes(attribute.Int("webhook.attempts", result.Attempts))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to deliver event")
+	}
+
+	status := metrics.StatusClass(result.StatusCode)
+	if err == nil && result.StatusCode == 0 {
+		status = "ok" // Sinks other than HTTP have no status code
+	}
+	metrics.Deliveries.WithLabelValues(label, status).Inc()
+	metrics.DeliveryDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	if err == nil && !eventJson.OccurredAt.IsZero() {
+		metrics.EventLag.Observe(time.Since(eventJson.OccurredAt).Seconds())
+	}
+	return result, err
+}
+
+// send makes the delivery attempts for Send
+func send(
+	log *slog.Logger,
+	ctx context.Context,
+	endpoint Endpoint,
+	eventJson parser.ProcessedEvent,
+) (*DeliveryResult, error) {
+	result := &DeliveryResult{}
+	label := endpoint.label() // urls may have credentials, so are not logged
+
 	// Marshal the payload to JSON
 	marshaledPayload, err := json.Marshal(eventJson)
 	if err != nil {
 		log.Error("failed to marshal payload to JSON", "error", err)
-		return
+		return result, err
+	}
+
+	// The same message id is sent for each attempt, so consumers can
+	// identify retries, and events delivered again
+	msgId, err := messageId(eventJson)
+	if err != nil {
+		return result, err
 	}
 
+	policy := RetryPolicy{MaxAttempts: 1}
+	if endpoint.RetryPolicy != nil {
+		policy = *endpoint.RetryPolicy
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	sink := endpoint.sink()
+	msg := Message{ID: msgId, Event: eventJson, Payload: marshaledPayload}
+
+	for {
+		result.Attempts++
+
+		resp, err := sink.Deliver(ctx, msg)
+		var retryable bool
+		var delay time.Duration
+
+		if err != nil {
+			result.StatusCode = 0
+			result.ResponseBody = ""
+			// Network errors are retryable, unless the context was cancelled
+			retryable = ctx.Err() == nil
+		} else {
+			result.StatusCode = resp.StatusCode
+			result.ResponseBody = resp.Body
+
+			// Check the response status, if any
+			if resp.StatusCode == 0 || (resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices) {
+				log.Info(
+					"webhook called successfully",
+					"status", resp.StatusCode,
+					"endpoint", label,
+					"attempts", result.Attempts,
+				)
+				return result, nil
+			}
+
+			err = fmt.Errorf("webhook responded with status code %d", resp.StatusCode)
+			retryable = policy.isRetryableStatus(resp.StatusCode)
+			if policy.RespectRetryAfter {
+				delay, _ = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			}
+		}
+
+		if !retryable || result.Attempts >= policy.MaxAttempts {
+			log.Error(
+				"failed to call webhook",
+				"endpoint", label,
+				"requestPayload", eventJson,
+				"responseCode", result.StatusCode,
+				"responseBody", result.ResponseBody,
+				"attempts", result.Attempts,
+				"error", err,
+			)
+			return result, err
+		}
+
+		// A Retry-After longer than the maximum delay is not waited for, so
+		// one endpoint cannot hold a worker (and the events behind it) for long
+		delay = max(delay, policy.backoff(result.Attempts))
+		if policy.MaxDelay > 0 {
+			delay = min(delay, policy.MaxDelay)
+		}
+		metrics.Retries.WithLabelValues(label).Inc()
+		log.Warn(
+			"webhook request failed, retrying",
+			"endpoint", label,
+			"responseCode", result.StatusCode,
+			"attempt", result.Attempts,
+			"retryIn", delay.String(),
+			"error", err,
+		)
+
+		select {
+		case <-ctx.Done():
+			return result, errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// The maximum length of a response body kept, e.g. in the dead letter queue
+const maxResponseBody = 64 << 10
+
+// response holds the parts of a webhook response needed after the body is closed
+type response struct {
+	statusCode int
+	body       string
+	header     http.Header
+	token      string // The OAuth2 access token sent, if any
+}
+
+// HeaderIdempotencyKey is sent with every request, with the same value as
+// the webhook-id, so receivers can skip events they already processed
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// messageId returns the webhook-id of the event, from the event id or
+// outbox id if known so it is the same if the event is delivered again,
+// else random
+func messageId(event parser.ProcessedEvent) (string, error) {
+	if event.EventId != "" {
+		return event.EventId, nil
+	}
+	if event.OutboxId != 0 {
+		return fmt.Sprintf("msg_%d", event.OutboxId), nil
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate message id: %w", err)
+	}
+	return "msg_" + hex.EncodeToString(random), nil
+}
+
+// httpSink POSTs events to the endpoint url, with the endpoint headers and
+// authentication. It is the sink for http and https urls.
+type httpSink struct {
+	endpoint Endpoint
+}
+
+// Deliver makes a single POST request with the JSON payload. If an OAuth2
+// token is rejected, the request is sent once more with a new token.
+func (s httpSink) Deliver(ctx context.Context, msg Message) (*Response, error) {
+	resp, err := post(ctx, s.endpoint, msg.ID, msg.Payload)
+	if err == nil && resp.statusCode == http.StatusUnauthorized && resp.token != "" {
+		// The token may have been revoked before it expired
+		s.endpoint.Auth.OAuth2.invalidate(resp.token)
+		resp, err = post(ctx, s.endpoint, msg.ID, msg.Payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: resp.statusCode, Body: resp.body, Header: resp.header}, nil
+}
+
+// Close does nothing, as the client of the endpoint is shared, see
+// Endpoint.Client
+func (s httpSink) Close() error { return nil }
+
+// post sends the payload to the endpoint
+func post(
+	ctx context.Context,
+	endpoint Endpoint,
+	msgId string,
+	payload []byte,
+) (_ *response, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, http.MethodPost, trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "request failed")
+		}
+		span.End()
+	}()
+
 	// Create the HTTP request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiEndpoint, bytes.NewBuffer(marshaledPayload))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewBuffer(payload))
 	if err != nil {
-		log.Error("failed to create HTTP request", "error", err)
-		return
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	span.SetAttributes(
+		attribute.String("http.request.method", http.MethodPost),
+		attribute.String("server.address", req.URL.Hostname()),
+		attribute.String("webhook.endpoint", endpoint.label()),
+	)
+	for key, value := range endpoint.Headers {
+		req.Header.Set(key, value)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	// Add X-API-Key header if apiKey is provided
-	if apiKey != nil {
-		req.Header.Set("X-API-Key", *apiKey)
+	req.Header.Set(HeaderIdempotencyKey, msgId)
+	tracing.Inject(ctx, req.Header)
+	// Add X-API-Key, Authorization or signature headers, if configured
+	if err := endpoint.Auth.apply(req, msgId, payload); err != nil {
+		return nil, fmt.Errorf("failed to authenticate HTTP request: %w", err)
 	}
 
 	// Send the request
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := endpoint.client().Do(req)
 	if err != nil {
-		log.Error("failed to send HTTP request", "error", err)
-		return
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
 	}
-	respBodyBytes, err := io.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	respBodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
 	if err != nil {
-		log.Error("failed to read response body", "error", err)
-		return
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	// Read the rest of a long response, so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
 	}
-	respBodyString := string(respBodyBytes)
-	defer resp.Body.Close()
 
-	// Check the response status
-	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
-		log.Info("webhook called successfully", "status", resp.StatusCode, "endpoint", apiEndpoint)
-	} else {
-		log.Error(
-			"failed to call webhook",
-			"endpoint", apiEndpoint,
-			"requestPayload", eventJson,
-			"responseCode", resp.StatusCode,
-			"responseBody", respBodyString)
+	result := &response{
+		statusCode: resp.StatusCode,
+		body:       string(respBodyBytes),
+		header:     resp.Header,
+	}
+	if endpoint.Auth != nil && endpoint.Auth.OAuth2 != nil {
+		result.token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
 	}
+	return result, nil
 }
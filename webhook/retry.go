@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how failed webhook requests are retried.
+//
+// The delay before each retry doubles from BaseDelay, up to MaxDelay,
+// with a random proportion (Jitter, from 0 to 1) subtracted from each
+// delay to avoid many retries hitting the endpoint at the same time.
+type RetryPolicy struct {
+	MaxAttempts          int           // Total attempts, including the first request
+	BaseDelay            time.Duration // Delay before the first retry
+	MaxDelay             time.Duration // Upper limit for the delay between attempts
+	Jitter               float64       // Proportion of the delay to randomise, 0 to 1
+	RetryableStatusCodes []int         // Response codes that should be retried
+	RespectRetryAfter    bool          // Wait for the Retry-After response header, if sent, up to MaxDelay
+}
+
+// DefaultRetryPolicy retries transient network errors and 429/5xx gateway errors
+// up to 5 times in total, waiting up to 30 seconds between attempts.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   1 * time.Second,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.2,
+		RetryableStatusCodes: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		RespectRetryAfter: true,
+	}
+}
+
+// isRetryableStatus returns true if the response code should be retried
+func (p RetryPolicy) isRetryableStatus(statusCode int) bool {
+	return slices.Contains(p.RetryableStatusCodes, statusCode)
+}
+
+// backoff returns the delay to wait after the given (1-indexed) attempt failed
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		delay -= delay * jitter * rand.Float64()
+	}
+
+	return time.Duration(delay)
+}
+
+// retryAfter parses the Retry-After header, which may be either a number of
+// seconds or a HTTP date. False is returned if the header is missing or invalid.
+func retryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	is := is.New(t)
+
+	policy := RetryPolicy{
+		BaseDelay: 1 * time.Second,
+		MaxDelay:  5 * time.Second,
+	}
+
+	// Without jitter, the delay doubles up to the maximum
+	is.Equal(1*time.Second, policy.backoff(1))
+	is.Equal(2*time.Second, policy.backoff(2))
+	is.Equal(4*time.Second, policy.backoff(3))
+	is.Equal(5*time.Second, policy.backoff(4))
+	is.Equal(5*time.Second, policy.backoff(10))
+
+	// With jitter, the delay is reduced by up to the jitter proportion
+	policy.Jitter = 0.5
+	for range 100 {
+		delay := policy.backoff(2)
+		is.True(delay >= 1*time.Second)
+		is.True(delay <= 2*time.Second)
+	}
+}
+
+func TestRetryPolicyStatusCodes(t *testing.T) {
+	is := is.New(t)
+	policy := DefaultRetryPolicy()
+
+	is.True(policy.isRetryableStatus(429))
+	is.True(policy.isRetryableStatus(502))
+	is.True(policy.isRetryableStatus(503))
+	is.True(!policy.isRetryableStatus(400))
+	is.True(!policy.isRetryableStatus(401))
+	is.True(!policy.isRetryableStatus(422))
+}
+
+func TestRetryAfter(t *testing.T) {
+	is := is.New(t)
+	now := time.Date(2025, 1, 10, 16, 0, 0, 0, time.UTC)
+
+	t.Run("Seconds", func(t *testing.T) {
+		delay, ok := retryAfter("120", now)
+		is.True(ok)
+		is.Equal(120*time.Second, delay)
+	})
+
+	t.Run("HTTP Date", func(t *testing.T) {
+		delay, ok := retryAfter("Fri, 10 Jan 2025 16:00:30 GMT", now)
+		is.True(ok)
+		is.Equal(30*time.Second, delay)
+	})
+
+	t.Run("Date In The Past", func(t *testing.T) {
+		delay, ok := retryAfter("Fri, 10 Jan 2025 15:00:00 GMT", now)
+		is.True(ok)
+		is.Equal(time.Duration(0), delay)
+	})
+
+	t.Run("Missing Or Invalid", func(t *testing.T) {
+		_, ok := retryAfter("", now)
+		is.True(!ok)
+		_, ok = retryAfter("soon", now)
+		is.True(!ok)
+		_, ok = retryAfter("-5", now)
+		is.True(!ok)
+	})
+}
@@ -0,0 +1,295 @@
+package webhook
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hotosm/central-webhook/parser"
+)
+
+// Sink is an output that events are delivered to. Send chooses the sink of
+// an endpoint by its url scheme, unless the endpoint has its own:
+//
+//   - http and https: POST the event to the url
+//   - file: append the event to a JSON lines file, e.g. file:///var/log/events.jsonl
+//   - stdout: write the event to stdout as a line of JSON, i.e. stdout:
+//   - exec: run a command with the event on stdin, e.g. exec:/usr/local/bin/handle-event
+//
+// Other schemes may be added with RegisterSink, e.g. for message brokers.
+// Events are retried and dead lettered the same for all sinks.
+type Sink interface {
+	// Deliver makes one attempt to deliver the message. An error is returned
+	// if it may not have been delivered, to be retried. Only HTTP sinks
+	// return a response with a status code.
+	Deliver(ctx context.Context, msg Message) (*Response, error)
+	// Close closes any connection the sink keeps open, see CloseSinks
+	Close() error
+}
+
+// Message is an event to deliver to a sink
+type Message struct {
+	ID      string // The webhook-id, the same for each attempt
+	Event   parser.ProcessedEvent
+	Payload []byte // The event as JSON
+}
+
+// Response is the result of a delivery attempt
+type Response struct {
+	StatusCode int // The HTTP status code, or 0 for other sinks
+	Body       string
+	Header     http.Header
+}
+
+// sink returns the sink of the endpoint, by its url scheme if not set
+func (endpoint Endpoint) sink() Sink {
+	if endpoint.Sink != nil {
+		return endpoint.Sink
+	}
+	sink, err := ParseSink(endpoint.URL)
+	if err != nil {
+		return invalidSink{err: err}
+	}
+	if sink != nil {
+		return sink
+	}
+	return httpSink{endpoint: endpoint} // the request reports an invalid url
+}
+
+// invalidSink fails each delivery with the error of its url
+type invalidSink struct {
+	err error
+}
+
+func (s invalidSink) Deliver(ctx context.Context, msg Message) (*Response, error) {
+	return nil, s.err
+}
+
+func (s invalidSink) Close() error { return nil }
+
+// SinkFactory returns the sink for a url with a registered scheme, or an
+// error if the url is invalid, e.g. an invalid query value. It is called
+// once for each url, so should not connect.
+type SinkFactory func(u *url.URL) (Sink, error)
+
+var (
+	sinkFactories   = map[string]SinkFactory{}
+	sinkFactoriesMu sync.RWMutex
+	// The sinks of registered schemes, by url
+	registeredSinks sync.Map
+)
+
+// RegisterSink adds a sink for urls with the scheme. The sink for each url is
+// created once, and shared by every endpoint and route with the url, so it
+// may keep a connection open.
+func RegisterSink(scheme string, factory SinkFactory) {
+	sinkFactoriesMu.Lock()
+	defer sinkFactoriesMu.Unlock()
+	sinkFactories[scheme] = factory
+}
+
+// CloseSinks closes and removes the sinks of registered schemes, except
+// those for the urls to keep, e.g. the urls still routed to after a config
+// reload. An event still being delivered to a closed sink reconnects it.
+func CloseSinks(keep ...string) error {
+	var errs []error
+	registeredSinks.Range(func(key, sink any) bool {
+		if slices.Contains(keep, key.(string)) {
+			return true
+		}
+		registeredSinks.Delete(key)
+		if err := sink.(Sink).Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close sink %s: %w", RedactURL(key.(string)), err))
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// SinkSchemes returns the url schemes that events can be sent to, the
+// built in schemes then the registered schemes, sorted
+func SinkSchemes() []string {
+	sinkFactoriesMu.RLock()
+	registered := slices.Sorted(maps.Keys(sinkFactories))
+	sinkFactoriesMu.RUnlock()
+	return append([]string{"http", "https", "file", "stdout", "exec"}, registered...)
+}
+
+// SinkForUrl returns the sink for a url with the file, stdout or exec
+// scheme, or a registered scheme, or nil for any other url, e.g. http, or
+// an invalid url
+func SinkForUrl(value string) Sink {
+	sink, _ := ParseSink(value)
+	return sink
+}
+
+// ParseSink returns the sink for a url as SinkForUrl, or the error of the
+// factory if the url has a registered scheme but is invalid
+func ParseSink(value string) (Sink, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, nil
+	}
+	path := cmp.Or(u.Opaque, u.Path)
+	switch {
+	case u.Scheme == "file" && path != "":
+		return FileSink{Path: path}, nil
+	case u.Scheme == "stdout":
+		return StdoutSink{}, nil
+	case u.Scheme == "exec" && path != "":
+		return ExecSink{Command: path}, nil
+	default:
+		return registeredSink(value, u)
+	}
+}
+
+// registeredSink returns the sink for a url with a registered scheme, created
+// by its factory the first time
+func registeredSink(value string, u *url.URL) (Sink, error) {
+	if sink, ok := registeredSinks.Load(value); ok {
+		return sink.(Sink), nil
+	}
+	sinkFactoriesMu.RLock()
+	factory := sinkFactories[u.Scheme]
+	sinkFactoriesMu.RUnlock()
+	if factory == nil {
+		return nil, nil
+	}
+	sink, err := factory(u)
+	if err != nil {
+		return nil, err
+	}
+	existing, _ := registeredSinks.LoadOrStore(value, sink)
+	return existing.(Sink), nil
+}
+
+// fileLocks serializes writes to each file, by path
+var fileLocks sync.Map
+
+// FileSink appends each event to a file as a line of JSON. The file is
+// opened for each event, so it may be rotated, e.g. by logrotate.
+type FileSink struct {
+	Path string
+}
+
+func (s FileSink) Deliver(ctx context.Context, msg Message) (*Response, error) {
+	lock, _ := fileLocks.LoadOrStore(s.Path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	file, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if _, err := file.Write(append(slices.Clip(msg.Payload), '\n')); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+	return &Response{}, nil
+}
+
+func (s FileSink) Close() error { return nil }
+
+// stdout is where StdoutSink writes, replaced in tests
+var (
+	stdout   io.Writer = os.Stdout
+	stdoutMu sync.Mutex
+)
+
+// StdoutSink writes each event to stdout as a line of JSON, e.g. for local
+// debugging without an API. The lines are between any log lines.
+type StdoutSink struct{}
+
+func (s StdoutSink) Deliver(ctx context.Context, msg Message) (*Response, error) {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	if _, err := stdout.Write(append(slices.Clip(msg.Payload), '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write to stdout: %w", err)
+	}
+	return &Response{}, nil
+}
+
+func (s StdoutSink) Close() error { return nil }
+
+// The time a command may run for, if the ExecSink has no timeout
+const defaultExecTimeout = 30 * time.Second
+
+// The length of the command output included in an error
+const maxExecErrorOutput = 1024
+
+// ExecSink runs a command for each event, with the event JSON on stdin. The
+// message id and event type are set in the CENTRAL_WEBHOOK_MESSAGE_ID and
+// CENTRAL_WEBHOOK_EVENT_TYPE environment variables. The event is delivered
+// if the command exits with status 0.
+type ExecSink struct {
+	Command string
+	Args    []string
+	Timeout time.Duration // 30s if 0
+}
+
+func (s ExecSink) Deliver(ctx context.Context, msg Message) (*Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(s.Timeout, defaultExecTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.Command, s.Args...)
+	cmd.Stdin = bytes.NewReader(msg.Payload)
+	cmd.Env = append(os.Environ(),
+		"CENTRAL_WEBHOOK_MESSAGE_ID="+msg.ID,
+		"CENTRAL_WEBHOOK_EVENT_TYPE="+msg.Event.Type,
+	)
+	output := &limitedBuffer{limit: maxResponseBody}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Do not wait long for the output of any child processes once killed
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if ctx.Err() != nil {
+			err = fmt.Errorf("command did not finish: %w", ctx.Err())
+		} else if errors.As(err, &exitErr) {
+			err = fmt.Errorf("command exited with status %d", exitErr.ExitCode())
+		} else {
+			err = fmt.Errorf("failed to run command: %w", err)
+		}
+		if out := strings.TrimSpace(output.String()); out != "" {
+			if len(out) > maxExecErrorOutput {
+				out = out[:maxExecErrorOutput] + "..."
+			}
+			err = fmt.Errorf("%w: %s", err, out)
+		}
+		return nil, err
+	}
+	return &Response{Body: output.String()}, nil
+}
+
+func (s ExecSink) Close() error { return nil }
+
+// limitedBuffer keeps the first bytes written to it, discarding the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.Len(); remaining > 0 {
+		b.Buffer.Write(p[:min(len(p), remaining)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/hotosm/central-webhook/parser"
+)
+
+func TestSinkForUrl(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(SinkForUrl("file:///var/log/events.jsonl"), FileSink{Path: "/var/log/events.jsonl"})
+	is.Equal(SinkForUrl("file:events.jsonl"), FileSink{Path: "events.jsonl"})
+	is.Equal(SinkForUrl("stdout:"), StdoutSink{})
+	is.Equal(SinkForUrl("exec:/usr/local/bin/handle-event"), ExecSink{Command: "/usr/local/bin/handle-event"})
+	is.Equal(SinkForUrl("exec:"), nil)
+	is.Equal(SinkForUrl("https://example.com/webhook"), nil)
+
+	is.Equal(Endpoint{URL: "https://example.com/webhook"}.sink(), httpSink{endpoint: Endpoint{URL: "https://example.com/webhook"}})
+}
+
+// testSink records the messages delivered to it
+type testSink struct {
+	mu       sync.Mutex
+	messages []Message
+	closed   bool
+}
+
+func (s *testSink) Deliver(ctx context.Context, msg Message) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return &Response{}, nil
+}
+
+func (s *testSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestRegisterSink(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	created := 0
+	RegisterSink("test", func(u *url.URL) (Sink, error) {
+		if u.Host == "" {
+			return nil, errors.New("host is required")
+		}
+		created++
+		return &testSink{}, nil
+	})
+
+	// The sink for each url is created once
+	sink := SinkForUrl("test://a")
+	is.True(sink != nil)
+	is.True(SinkForUrl("test://a") == sink)
+	is.True(SinkForUrl("test://b") != sink)
+	is.Equal(created, 2)
+	is.Equal(SinkForUrl("test:"), nil) // invalid
+	_, err := ParseSink("test:")
+	is.Equal(err.Error(), "host is required")
+	is.Equal(SinkSchemes(), []string{"http", "https", "file", "stdout", "exec", "test"})
+
+	// Invalid urls fail with the error
+	_, err = Send(log, context.Background(), Endpoint{URL: "test:"}, parser.ProcessedEvent{ID: "a"})
+	is.Equal(err.Error(), "host is required")
+
+	_, err = Send(log, context.Background(), Endpoint{URL: "test://a"}, parser.ProcessedEvent{ID: "a"})
+	is.NoErr(err)
+	is.Equal(len(sink.(*testSink).messages), 1)
+	is.Equal(sink.(*testSink).messages[0].Event.ID, "a")
+
+	// Sinks not kept are closed, and created again if used
+	other := SinkForUrl("test://b")
+	is.NoErr(CloseSinks("test://a"))
+	is.True(!sink.(*testSink).closed)
+	is.True(other.(*testSink).closed)
+	is.True(SinkForUrl("test://b") != other)
+	is.NoErr(CloseSinks())
+	is.True(sink.(*testSink).closed)
+}
+
+func TestFileSink(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	// Events are appended as lines of JSON
+	endpoint := Endpoint{URL: "file://" + path}
+	_, err := Send(log, context.Background(), endpoint, parser.ProcessedEvent{ID: "a", Type: "submission.create"})
+	is.NoErr(err)
+	result, err := Send(log, context.Background(), endpoint, parser.ProcessedEvent{ID: "b", Type: "submission.create"})
+	is.NoErr(err)
+	is.Equal(result.Attempts, 1)
+
+	data, err := os.ReadFile(path)
+	is.NoErr(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	is.Equal(len(lines), 2)
+	is.True(strings.Contains(lines[0], `"id":"a"`))
+	is.True(strings.Contains(lines[1], `"id":"b"`))
+
+	// A directory that does not exist fails, and is retried
+	policy := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	endpoint = Endpoint{URL: "file://" + filepath.Join(path, "missing", "events.jsonl"), RetryPolicy: &policy}
+	result, err = Send(log, context.Background(), endpoint, parser.ProcessedEvent{ID: "c"})
+	is.True(err != nil)
+	is.Equal(result.Attempts, 2)
+	is.Equal(result.StatusCode, 0)
+}
+
+func TestStdoutSink(t *testing.T) {
+	is := is.New(t)
+	log := slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	_, err := Send(log, context.Background(), Endpoint{URL: "stdout:"}, parser.ProcessedEvent{ID: "a", EventId: "evt_1"})
+	is.NoErr(err)
+	is.True(strings.Contains(out.String(), `"eventId":"evt_1"`))
+	is.True(strings.HasSuffix(out.String(), "}\n"))
+}
+
+func TestExecSink(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	msg := Message{ID: "evt_1", Event: parser.ProcessedEvent{Type: "submission.create"}, Payload: []byte(`{"id":"a"}`)}
+
+	// The event is on stdin, with the id and type in the environment
+	path := filepath.Join(t.TempDir(), "out")
+	sink := ExecSink{Command: "/bin/sh", Args: []string{"-c", `cat > "$0"; echo " $CENTRAL_WEBHOOK_MESSAGE_ID $CENTRAL_WEBHOOK_EVENT_TYPE" >> "$0"`, path}}
+	resp, err := sink.Deliver(ctx, msg)
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, 0)
+	data, err := os.ReadFile(path)
+	is.NoErr(err)
+	is.Equal(string(data), `{"id":"a"} evt_1 submission.create`+"\n")
+
+	// A non-zero exit status fails, with the output
+	sink = ExecSink{Command: "/bin/sh", Args: []string{"-c", "echo invalid event >&2; exit 3"}}
+	_, err = sink.Deliver(ctx, msg)
+	is.Equal(err.Error(), "command exited with status 3: invalid event")
+
+	// As does a command that runs too long
+	sink = ExecSink{Command: "/bin/sh", Args: []string{"-c", "exec sleep 5"}, Timeout: 10 * time.Millisecond}
+	_, err = sink.Deliver(ctx, msg)
+	is.True(err != nil)
+	is.True(strings.HasPrefix(err.Error(), "command did not finish"))
+}
@@ -0,0 +1,130 @@
+// Run jobs concurrently, while keeping jobs with the same key in order
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// The number of jobs queued per worker before Submit blocks
+const queueSize = 64
+
+var ErrClosed = errors.New("worker pool is closed")
+
+// Pool runs jobs on a fixed number of workers. Jobs with the same key run
+// one at a time, in the order submitted, while jobs with different keys run
+// concurrently on any free worker, so a slow key does not delay other keys.
+type Pool struct {
+	slots chan struct{} // A slot per queued or running job, to limit the queue
+	ready chan *keyQueue
+	jobs  sync.WaitGroup
+	wg    sync.WaitGroup
+
+	mu        sync.Mutex
+	keys      map[string]*keyQueue // Keys with a job queued or running
+	closed    bool
+	done      chan struct{} // closed by Close, to stop blocked calls to Submit
+	closeOnce sync.Once
+}
+
+// keyQueue is the jobs for a key, run by one worker at a time
+type keyQueue struct {
+	key  string
+	jobs []func()
+}
+
+// NewPool starts a pool with the number of concurrent workers (at least 1)
+func NewPool(concurrency int) *Pool {
+	concurrency = max(concurrency, 1)
+	pool := &Pool{
+		slots: make(chan struct{}, concurrency*queueSize),
+		ready: make(chan *keyQueue, concurrency*queueSize),
+		keys:  map[string]*keyQueue{},
+		done:  make(chan struct{}),
+	}
+	for range concurrency {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for queue := range pool.ready {
+				pool.runNext(queue)
+			}
+		}()
+	}
+	return pool
+}
+
+// Submit queues the job to run after the jobs already submitted with the
+// same key. If the queue is full, Submit blocks until there is space, or the
+// context is done.
+func (p *Pool) Submit(ctx context.Context, key string, job func()) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.done:
+		return ErrClosed
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		<-p.slots
+		return ErrClosed
+	}
+
+	p.jobs.Add(1)
+	if queue, ok := p.keys[key]; ok {
+		// Run by the worker already running the key
+		queue.jobs = append(queue.jobs, job)
+		return nil
+	}
+	queue := &keyQueue{key: key, jobs: []func(){job}}
+	p.keys[key] = queue
+	p.ready <- queue // never blocks, as there is a slot for each job
+	return nil
+}
+
+// runNext runs the next job for the key, then queues the key again if it
+// has more jobs, so other keys get a turn
+func (p *Pool) runNext(queue *keyQueue) {
+	p.mu.Lock()
+	job := queue.jobs[0]
+	p.mu.Unlock()
+
+	job()
+	<-p.slots
+	p.jobs.Done()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queue.jobs = queue.jobs[1:]
+	if len(queue.jobs) == 0 {
+		delete(p.keys, queue.key)
+		return
+	}
+	p.ready <- queue
+}
+
+// Wait waits for the jobs submitted so far to finish. It must not be called
+// at the same time as Submit.
+func (p *Pool) Wait() {
+	p.jobs.Wait()
+}
+
+// Close stops accepting jobs, and waits for the queued jobs to finish
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+
+		p.mu.Lock()
+		p.closed = true
+		p.mu.Unlock()
+
+		p.jobs.Wait()
+		close(p.ready)
+	})
+	p.wg.Wait()
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestPoolOrdering(t *testing.T) {
+	is := is.New(t)
+	pool := NewPool(4)
+
+	// Jobs for each key finish in the order submitted, even when earlier
+	// jobs take longer
+	var mu sync.Mutex
+	order := map[string][]int{}
+	for i := range 20 {
+		key := fmt.Sprintf("entity-%d", i%3)
+		err := pool.Submit(context.Background(), key, func() {
+			time.Sleep(time.Duration(20-i) * time.Millisecond / 10)
+			mu.Lock()
+			order[key] = append(order[key], i)
+			mu.Unlock()
+		})
+		is.NoErr(err)
+	}
+	pool.Close()
+
+	is.Equal(order["entity-0"], []int{0, 3, 6, 9, 12, 15, 18})
+	is.Equal(order["entity-1"], []int{1, 4, 7, 10, 13, 16, 19})
+	is.Equal(order["entity-2"], []int{2, 5, 8, 11, 14, 17})
+}
+
+func TestPoolConcurrency(t *testing.T) {
+	is := is.New(t)
+	pool := NewPool(4)
+	defer pool.Close()
+
+	// A slow job does not block jobs with other keys
+	block := make(chan struct{})
+	is.NoErr(pool.Submit(context.Background(), "slow", func() { <-block }))
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 50 {
+		key := fmt.Sprintf("key-%d", i)
+		wg.Add(1)
+		is.NoErr(pool.Submit(context.Background(), key, func() {
+			defer wg.Done()
+			n := running.Add(1)
+			for {
+				current := maxRunning.Load()
+				if n <= current || maxRunning.CompareAndSwap(current, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		}))
+	}
+	wg.Wait()
+	close(block)
+
+	is.True(maxRunning.Load() > 1)
+	is.True(maxRunning.Load() <= 3) // the slow job is using a worker
+}
+
+func TestPoolSubmitBlocks(t *testing.T) {
+	is := is.New(t)
+	pool := NewPool(1)
+
+	block := make(chan struct{})
+	is.NoErr(pool.Submit(context.Background(), "a", func() { <-block }))
+	for range queueSize - 1 {
+		is.NoErr(pool.Submit(context.Background(), "a", func() {}))
+	}
+
+	// The queue is full (including the running job), so Submit waits until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := pool.Submit(ctx, "a", func() {})
+	is.Equal(err, context.DeadlineExceeded)
+
+	close(block)
+	pool.Close()
+	is.Equal(pool.Submit(context.Background(), "a", func() {}), ErrClosed)
+}